@@ -0,0 +1,240 @@
+// netutil project breaker.go
+package netutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ErrCircuitOpen is returned without sending the request while the circuit of the host is open,
+//the Url* functions return httpretcode 7 for it.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+//CircuitBreaker keeps a circuit per host. FailureThreshold consecutive failures open it, requests
+//then fail with ErrCircuitOpen until Cooldown passed; then HalfOpenRequests trial requests are let
+//through, closing the circuit when all of them succeeded and opening it again when one fails.
+//Requests which were never sent, like those given up while waiting for the HostLimit, are not counted.
+type CircuitBreaker struct {
+	//FailureThreshold, 0 means 5.
+	FailureThreshold int
+	//Cooldown, 0 means 30s.
+	Cooldown time.Duration
+	//HalfOpenRequests, 0 means 1.
+	HalfOpenRequests int
+	//IsFailure classifies a result, nil counts errors and status codes >= 500 as failures.
+	IsFailure func(resp *http.Response, err error) bool
+	//OnStateChange, when not nil, is called after the circuit of host changed.
+	OnStateChange func(host string, from, to CircuitState)
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	state     CircuitState
+	failures  int
+	openedat  time.Time
+	trials    int
+	succeeded int
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{FailureThreshold: threshold, Cooldown: cooldown}
+}
+
+func (b *CircuitBreaker) circuit(host string) *circuit {
+	if b.hosts == nil {
+		b.hosts = map[string]*circuit{}
+	}
+	ci, ok := b.hosts[host]
+	if !ok {
+		ci = &circuit{}
+		b.hosts[host] = ci
+	}
+	return ci
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown <= 0 {
+		return 30 * time.Second
+	}
+	return b.Cooldown
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return b.HalfOpenRequests
+}
+
+//setState changes the state with b.mu held and returns the notification to call after unlocking.
+func (b *CircuitBreaker) setState(host string, ci *circuit, state CircuitState) func() {
+	from := ci.state
+	ci.state = state
+	switch state {
+	case CircuitOpen:
+		ci.openedat = time.Now()
+	case CircuitClosed:
+		ci.failures = 0
+	}
+	ci.trials = 0
+	ci.succeeded = 0
+	if b.OnStateChange == nil || from == state {
+		return func() {}
+	}
+	return func() { b.OnStateChange(host, from, state) }
+}
+
+//allow reports whether a request to host may be sent now.
+func (b *CircuitBreaker) allow(host string) bool {
+	b.mu.Lock()
+	ci := b.circuit(host)
+	notify := func() {}
+	if ci.state == CircuitOpen && time.Since(ci.openedat) >= b.cooldown() {
+		notify = b.setState(host, ci, CircuitHalfOpen)
+	}
+	allowed := true
+	switch ci.state {
+	case CircuitOpen:
+		allowed = false
+	case CircuitHalfOpen:
+		if ci.trials >= b.halfOpenRequests() {
+			allowed = false
+		} else {
+			ci.trials++
+		}
+	}
+	b.mu.Unlock()
+	notify()
+	return allowed
+}
+
+//record counts the result of a request to host.
+func (b *CircuitBreaker) record(host string, failed bool) {
+	b.mu.Lock()
+	ci := b.circuit(host)
+	notify := func() {}
+	switch {
+	case failed && ci.state == CircuitHalfOpen:
+		notify = b.setState(host, ci, CircuitOpen)
+	case failed:
+		ci.failures++
+		threshold := b.FailureThreshold
+		if threshold <= 0 {
+			threshold = 5
+		}
+		if ci.state == CircuitClosed && ci.failures >= threshold {
+			notify = b.setState(host, ci, CircuitOpen)
+		}
+	case ci.state == CircuitHalfOpen:
+		ci.succeeded++
+		if ci.succeeded >= b.halfOpenRequests() {
+			notify = b.setState(host, ci, CircuitClosed)
+		}
+	default:
+		ci.failures = 0
+	}
+	b.mu.Unlock()
+	notify()
+}
+
+//unsent gives back the trial taken by allow for a request to host without a result.
+func (b *CircuitBreaker) unsent(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ci := b.circuit(host); ci.state == CircuitHalfOpen && ci.trials > 0 {
+		ci.trials--
+	}
+}
+
+//State returns the state of the circuit of host.
+func (b *CircuitBreaker) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ci, ok := b.hosts[strings.ToLower(host)]
+	if !ok {
+		return CircuitClosed
+	}
+	if ci.state == CircuitOpen && time.Since(ci.openedat) >= b.cooldown() {
+		return CircuitHalfOpen
+	}
+	return ci.state
+}
+
+//States returns the state of every host seen, for dashboards.
+func (b *CircuitBreaker) States() map[string]CircuitState {
+	b.mu.Lock()
+	hosts := make([]string, 0, len(b.hosts))
+	for host := range b.hosts {
+		hosts = append(hosts, host)
+	}
+	b.mu.Unlock()
+	states := make(map[string]CircuitState, len(hosts))
+	for _, host := range hosts {
+		states[host] = b.State(host)
+	}
+	return states
+}
+
+//breakerTransport checks the CircuitBreaker before every request, redirects included.
+type breakerTransport struct {
+	breaker *CircuitBreaker
+	next    http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	if !t.breaker.allow(host) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrCircuitOpen
+	}
+	resp, err := t.next.RoundTrip(req)
+	var waiterr *hostWaitError
+	if errors.As(err, &waiterr) || t.breaker.IsFailure == nil && errors.Is(err, context.Canceled) {
+		//the request gave up waiting for the host limit or was canceled by its caller,
+		//that says nothing about the host
+		t.breaker.unsent(host)
+		return resp, err
+	}
+	var failed bool
+	if t.breaker.IsFailure != nil {
+		failed = t.breaker.IsFailure(resp, err)
+	} else {
+		failed = err != nil || resp.StatusCode >= 500
+	}
+	t.breaker.record(host, failed)
+	return resp, err
+}
+
+//doErrCode is the httpretcode of an error returned by Client.do.
+func doErrCode(err error) int {
+	if errors.Is(err, ErrCircuitOpen) {
+		return 7
+	}
+	return 2
+}
@@ -0,0 +1,157 @@
+// netutil project breaker_test.go
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newFlakyServer answers 500 while *failing is set, 200 otherwise.
+func newFlakyServer(t *testing.T) (srv *testServer, failing *int32) {
+	failing = new(int32)
+	srv = newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(failing) != 0 {
+			w.WriteHeader(500)
+		}
+	})
+	return srv, failing
+}
+
+func breakerGet(c *Client, httpurl string) int {
+	_, _, _, code, _ := c.UrlGet(httpurl, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	return code
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	srv, failing := newFlakyServer(t)
+	var changes []string
+	b := NewCircuitBreaker(3, time.Hour)
+	b.OnStateChange = func(host string, from, to CircuitState) {
+		changes = append(changes, fmt.Sprint(host, " ", from, "->", to))
+	}
+	c := &Client{Breaker: b}
+
+	atomic.StoreInt32(failing, 1)
+	breakerGet(c, srv.URL)
+	breakerGet(c, srv.URL)
+	//a success resets the consecutive failures
+	atomic.StoreInt32(failing, 0)
+	breakerGet(c, srv.URL)
+	atomic.StoreInt32(failing, 1)
+	for i := 0; i < 2; i++ {
+		if code := breakerGet(c, srv.URL); code != 500 {
+			t.Fatalf("failure %d: httpretcode %d", i, code)
+		}
+	}
+	if b.State("127.0.0.1") != CircuitClosed {
+		t.Fatalf("opened after 2 consecutive failures")
+	}
+	breakerGet(c, srv.URL)
+	if b.State("127.0.0.1") != CircuitOpen {
+		t.Fatalf("state %v after 3 consecutive failures", b.State("127.0.0.1"))
+	}
+	sent := srv.requests()
+	if code := breakerGet(c, srv.URL); code != 7 || srv.requests() != sent {
+		t.Errorf("open circuit: httpretcode %d, request sent %v", code, srv.requests() != sent)
+	}
+	if fmt.Sprint(changes) != "[127.0.0.1 closed->open]" {
+		t.Errorf("state changes %v", changes)
+	}
+	if states := b.States(); len(states) != 1 || states["127.0.0.1"] != CircuitOpen {
+		t.Errorf("States %v", states)
+	}
+	//another host is not affected
+	if code := breakerGet(c, "http://localhost:"+backendPort(srv)); code != 500 {
+		t.Errorf("another host: httpretcode %d", code)
+	}
+}
+
+//TestCircuitBreakerHalfOpen checks all trial requests must succeed to close the circuit
+//and one failure opens it again.
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	srv, failing := newFlakyServer(t)
+	b := NewCircuitBreaker(1, 50*time.Millisecond)
+	b.HalfOpenRequests = 2
+	c := &Client{Breaker: b}
+
+	atomic.StoreInt32(failing, 1)
+	breakerGet(c, srv.URL)
+	time.Sleep(60 * time.Millisecond)
+	if b.State("127.0.0.1") != CircuitHalfOpen {
+		t.Fatalf("state %v after the cooldown", b.State("127.0.0.1"))
+	}
+	atomic.StoreInt32(failing, 0)
+	if code := breakerGet(c, srv.URL); code != 200 || b.State("127.0.0.1") != CircuitHalfOpen {
+		t.Fatalf("closed after 1 of 2 trials: httpretcode %d state %v", code, b.State("127.0.0.1"))
+	}
+	if code := breakerGet(c, srv.URL); code != 200 || b.State("127.0.0.1") != CircuitClosed {
+		t.Fatalf("not closed after 2 trials: httpretcode %d state %v", code, b.State("127.0.0.1"))
+	}
+
+	//a failed trial opens the circuit again
+	atomic.StoreInt32(failing, 1)
+	breakerGet(c, srv.URL)
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(failing, 0)
+	breakerGet(c, srv.URL)
+	atomic.StoreInt32(failing, 1)
+	breakerGet(c, srv.URL)
+	if b.State("127.0.0.1") != CircuitOpen {
+		t.Errorf("state %v after a failed trial", b.State("127.0.0.1"))
+	}
+}
+
+func TestCircuitBreakerTrials(t *testing.T) {
+	b := NewCircuitBreaker(1, time.Millisecond)
+	b.HalfOpenRequests = 2
+	b.allow("a.test")
+	b.record("a.test", true)
+	time.Sleep(5 * time.Millisecond)
+	//only HalfOpenRequests requests are let through at once
+	if !b.allow("a.test") || !b.allow("a.test") || b.allow("a.test") {
+		t.Fatal("half-open circuit let through a wrong number of trials")
+	}
+	//a trial which was never sent is given back
+	b.unsent("a.test")
+	if !b.allow("a.test") {
+		t.Error("the trial of an unsent request was not given back")
+	}
+}
+
+//TestCircuitBreakerHostWait checks requests given up while waiting for the HostLimit
+//are not failures of the host.
+func TestCircuitBreakerHostWait(t *testing.T) {
+	srv, _ := newFlakyServer(t)
+	b := NewCircuitBreaker(1, time.Hour)
+	c := &Client{Breaker: b, HostLimit: NewHostLimiter(1, 1, 0, 0)}
+	breakerGet(c, srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if code := breakerGet(c.WithContext(ctx), srv.URL); code != 2 {
+		t.Fatalf("httpretcode %d, want 2 for the wait given up", code)
+	}
+	if b.State("127.0.0.1") != CircuitClosed {
+		t.Errorf("a host limit wait opened the circuit")
+	}
+}
+
+func TestCircuitBreakerIsFailure(t *testing.T) {
+	srv, failing := newFlakyServer(t)
+	b := NewCircuitBreaker(1, time.Hour)
+	b.IsFailure = func(resp *http.Response, err error) bool { return err != nil }
+	c := &Client{Breaker: b}
+	atomic.StoreInt32(failing, 1)
+	breakerGet(c, srv.URL)
+	if b.State("127.0.0.1") != CircuitClosed {
+		t.Errorf("a 500 opened the circuit with IsFailure counting errors only")
+	}
+	breakerGet(c, "http://127.0.0.1:1/")
+	if b.State("127.0.0.1") != CircuitOpen {
+		t.Errorf("a connection error did not open the circuit")
+	}
+}
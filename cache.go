@@ -0,0 +1,467 @@
+// netutil project cache.go
+package netutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Cache is an HTTP cache (RFC 9111) for GET requests, set it as Client.Cache. Fresh responses are served
+//without a request, stale ones are revalidated with If-None-Match and If-Modified-Since. The response
+//directives max-age, s-maxage, no-store, no-cache, private, must-revalidate, stale-while-revalidate
+//and stale-if-error, the request directives max-age, max-stale, min-fresh, no-cache, no-store and
+//only-if-cached, Expires and Vary are honored. Requests with a Range or their own conditional headers
+//bypass the cache. Responses served by the cache carry an Age and an "X-From-Cache: 1" header.
+type Cache struct {
+	Storage CacheStorage
+	//Shared makes it a shared cache: private responses and, without public, responses to requests
+	//with Authorization are not stored and s-maxage applies.
+	Shared bool
+	//MaxEntrySize is the largest body stored, 0 means 8MB.
+	MaxEntrySize int64
+
+	mu           sync.Mutex
+	revalidating map[string]bool
+}
+
+func NewCache(storage CacheStorage) *Cache {
+	return &Cache{Storage: storage}
+}
+
+//cacheEntry is a stored response.
+type cacheEntry struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	RequestTime  time.Time
+	ResponseTime time.Time
+	//Vary holds the request header values named by the Vary header of the response.
+	Vary map[string]string
+}
+
+func cacheKey(req *http.Request) string {
+	return "GET " + req.URL.String()
+}
+
+//parseCacheControl returns the directives of the Cache-Control headers with lower case names.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+//seconds returns the delta-seconds value of directive name, ok false when missing or invalid.
+func seconds(cc map[string]string, name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func (c *Cache) load(key string, req *http.Request) *cacheEntry {
+	data, ok := c.Storage.Get(key)
+	if !ok {
+		return nil
+	}
+	e := &cacheEntry{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(e); err != nil {
+		c.Storage.Delete(key)
+		return nil
+	}
+	for name, value := range e.Vary {
+		if strings.Join(req.Header.Values(name), ",") != value {
+			return nil
+		}
+	}
+	return e
+}
+
+func (c *Cache) save(key string, e *cacheEntry) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err == nil {
+		c.Storage.Set(key, buf.Bytes())
+	}
+}
+
+//age is the current age of e (RFC 9111 4.2.3).
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	apparent := e.ResponseTime.Sub(date)
+	if apparent < 0 {
+		apparent = 0
+	}
+	agevalue, _ := seconds(map[string]string{"age": e.Header.Get("Age")}, "age")
+	corrected := agevalue + e.ResponseTime.Sub(e.RequestTime)
+	if apparent > corrected {
+		corrected = apparent
+	}
+	return corrected + now.Sub(e.ResponseTime)
+}
+
+//heuristicStatus are the status codes cacheable without explicit freshness (RFC 9110 15.1).
+func heuristicStatus(code int) bool {
+	switch code {
+	case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
+
+//lifetime is the freshness lifetime of e (RFC 9111 4.2.1), with 10% of the time since
+//Last-Modified, at most a day, as heuristic.
+func (c *Cache) lifetime(e *cacheEntry) time.Duration {
+	cc := parseCacheControl(e.Header)
+	if c.Shared {
+		if d, ok := seconds(cc, "s-maxage"); ok {
+			return d
+		}
+	}
+	if d, ok := seconds(cc, "max-age"); ok {
+		return d
+	}
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if lastmodified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && heuristicStatus(e.StatusCode) {
+		if d := date.Sub(lastmodified) / 10; d > 0 {
+			if d > 24*time.Hour {
+				d = 24 * time.Hour
+			}
+			return d
+		}
+	}
+	return 0
+}
+
+//storable reports whether the response to req may be stored (RFC 9111 3).
+func (c *Cache) storable(req *http.Request, resp *http.Response) bool {
+	reqcc, cc := parseCacheControl(req.Header), parseCacheControl(resp.Header)
+	if _, ok := reqcc["no-store"]; ok {
+		return false
+	}
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if strings.TrimSpace(resp.Header.Get("Vary")) == "*" {
+		return false
+	}
+	if c.Shared {
+		if _, ok := cc["private"]; ok {
+			return false
+		}
+		if req.Header.Get("Authorization") != "" {
+			_, public := cc["public"]
+			_, smaxage := cc["s-maxage"]
+			_, mustrevalidate := cc["must-revalidate"]
+			if !public && !smaxage && !mustrevalidate {
+				return false
+			}
+		}
+	}
+	if heuristicStatus(resp.StatusCode) {
+		return true
+	}
+	_, maxage := cc["max-age"]
+	return (resp.StatusCode == 302 || resp.StatusCode == 307) && (maxage || resp.Header.Get("Expires") != "")
+}
+
+//response builds the response served from e.
+func (e *cacheEntry) response(req *http.Request, now time.Time) *http.Response {
+	header := http.Header{}
+	for k, v := range e.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	header.Set("X-From-Cache", "1")
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+//clone returns a deep copy of e.
+func (e *cacheEntry) clone() *cacheEntry {
+	c := *e
+	c.Header = e.Header.Clone()
+	if e.Vary != nil {
+		c.Vary = map[string]string{}
+		for k, v := range e.Vary {
+			c.Vary[k] = v
+		}
+	}
+	return &c
+}
+
+//Invalidate removes the stored response of httpurl.
+func (c *Cache) Invalidate(httpurl string) {
+	c.Storage.Delete("GET " + httpurl)
+}
+
+//cacheTransport answers from the Cache or forwards to next.
+type cacheTransport struct {
+	cache *Cache
+	next  http.RoundTripper
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		resp, err := t.next.RoundTrip(req)
+		//an unsafe method changing the resource invalidates it (RFC 9111 4.4)
+		if err == nil && req.Method != "HEAD" && req.Method != "OPTIONS" && req.Method != "TRACE" && resp.StatusCode < 400 {
+			t.cache.Invalidate(req.URL.String())
+		}
+		return resp, err
+	}
+	if req.Header.Get("Range") != "" || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return t.next.RoundTrip(req)
+	}
+	reqcc := parseCacheControl(req.Header)
+	if _, ok := reqcc["no-store"]; ok {
+		return t.next.RoundTrip(req)
+	}
+	key := cacheKey(req)
+	e := t.cache.load(key, req)
+	_, onlyifcached := reqcc["only-if-cached"]
+	if e == nil {
+		if onlyifcached {
+			return gatewayTimeout(req), nil
+		}
+		return t.forward(req, key, time.Now())
+	}
+
+	now := time.Now()
+	age, lifetime := e.age(now), t.cache.lifetime(e)
+	cc := parseCacheControl(e.Header)
+	fresh := age < lifetime
+	if d, ok := seconds(reqcc, "max-age"); ok && age > d {
+		fresh = false
+	}
+	if d, ok := seconds(reqcc, "min-fresh"); ok && lifetime-age < d {
+		fresh = false
+	}
+	_, reqnocache := reqcc["no-cache"]
+	_, nocache := cc["no-cache"]
+	_, mustrevalidate := cc["must-revalidate"]
+	if _, ok := cc["proxy-revalidate"]; ok && t.cache.Shared {
+		mustrevalidate = true
+	}
+	if !reqnocache && !nocache {
+		if fresh {
+			return e.response(req, now), nil
+		}
+		stale := age - lifetime
+		if !mustrevalidate {
+			if v, ok := reqcc["max-stale"]; ok {
+				if d, ok := seconds(reqcc, "max-stale"); v == "" || ok && stale <= d {
+					return e.response(req, now), nil
+				}
+			}
+			if d, ok := seconds(cc, "stale-while-revalidate"); ok && stale <= d {
+				t.revalidateBackground(req, key, e)
+				return e.response(req, now), nil
+			}
+		}
+	}
+	if onlyifcached {
+		return gatewayTimeout(req), nil
+	}
+
+	resp, err := t.revalidate(req, key, e)
+	if err != nil || resp.StatusCode >= 500 {
+		//stale-if-error (RFC 5861) from the response or the request
+		stale := age - lifetime
+		d, ok := seconds(cc, "stale-if-error")
+		if rd, rok := seconds(reqcc, "stale-if-error"); rok {
+			d, ok = rd, true
+		}
+		if ok && !mustrevalidate && stale <= d {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return e.response(req, time.Now()), nil
+		}
+	}
+	return resp, err
+}
+
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 " + http.StatusText(http.StatusGatewayTimeout),
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+//forward sends req and stores the response while its body is read.
+func (t *cacheTransport) forward(req *http.Request, key string, reqtime time.Time) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.store(req, key, resp, reqtime), nil
+}
+
+//store makes resp store itself when its body is read to the end, if it is storable.
+func (t *cacheTransport) store(req *http.Request, key string, resp *http.Response, reqtime time.Time) *http.Response {
+	if !t.cache.storable(req, resp) {
+		return resp
+	}
+	e := &cacheEntry{StatusCode: resp.StatusCode, Header: resp.Header, RequestTime: reqtime, ResponseTime: time.Now()}
+	for _, name := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(name, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" {
+				if e.Vary == nil {
+					e.Vary = map[string]string{}
+				}
+				e.Vary[name] = strings.Join(req.Header.Values(name), ",")
+			}
+		}
+	}
+	max := t.cache.MaxEntrySize
+	if max <= 0 {
+		max = 8 << 20
+	}
+	resp.Body = &cachingBody{ReadCloser: resp.Body, max: max, done: func(body []byte) {
+		e.Body = body
+		t.cache.save(key, e)
+	}}
+	return resp
+}
+
+//revalidate sends req conditionally on the validators of e, a 304 stores and serves an updated copy of e.
+func (t *cacheTransport) revalidate(req *http.Request, key string, e *cacheEntry) (*http.Response, error) {
+	creq := req.Clone(req.Context())
+	if etag := e.Header.Get("ETag"); etag != "" {
+		creq.Header.Set("If-None-Match", etag)
+	}
+	if lastmodified := e.Header.Get("Last-Modified"); lastmodified != "" {
+		creq.Header.Set("If-Modified-Since", lastmodified)
+	}
+	reqtime := time.Now()
+	if creq.Header.Get("If-None-Match") == "" && creq.Header.Get("If-Modified-Since") == "" {
+		return t.forward(req, key, reqtime)
+	}
+	resp, err := t.next.RoundTrip(creq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		resp.Request = req
+		return t.store(req, key, resp, reqtime), nil
+	}
+	resp.Body.Close()
+	//the 304 updates the stored header fields (RFC 9111 4.3.4)
+	e = e.clone()
+	for k, v := range resp.Header {
+		if k != "Content-Length" {
+			e.Header[k] = v
+		}
+	}
+	e.RequestTime, e.ResponseTime = reqtime, time.Now()
+	t.cache.save(key, e)
+	return e.response(req, time.Now()), nil
+}
+
+//revalidateBackground revalidates e once at a time per key, for stale-while-revalidate. e must not
+//be used by the caller anymore.
+func (t *cacheTransport) revalidateBackground(req *http.Request, key string, e *cacheEntry) {
+	t.cache.mu.Lock()
+	if t.cache.revalidating == nil {
+		t.cache.revalidating = map[string]bool{}
+	}
+	if t.cache.revalidating[key] {
+		t.cache.mu.Unlock()
+		return
+	}
+	t.cache.revalidating[key] = true
+	t.cache.mu.Unlock()
+	//the caller's context ends with its request
+	ctx, _ := withRequestProxy(context.Background())
+	breq := req.Clone(ctx)
+	go func() {
+		defer func() {
+			t.cache.mu.Lock()
+			delete(t.cache.revalidating, key)
+			t.cache.mu.Unlock()
+		}()
+		resp, err := t.revalidate(breq, key, e)
+		if err == nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+}
+
+//cachingBody keeps what is read and hands it to done at EOF, unless it exceeds max.
+type cachingBody struct {
+	io.ReadCloser
+	max  int64
+	buf  bytes.Buffer
+	over bool
+	done func(body []byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.over {
+		if int64(b.buf.Len()+n) > b.max {
+			b.over = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.over && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
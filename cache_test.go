@@ -0,0 +1,245 @@
+// netutil project cache_test.go
+package netutil
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//cacheServer answers every path with the Cache-Control of its path after /cc/, e.g. /cc/max-age=60,
+//an ETag and the number of requests to the path as body. It answers 304 to a matching If-None-Match
+//and 500 to every request once failing is set.
+type cacheServer struct {
+	*testServer
+	mu      sync.Mutex
+	hits    map[string]int
+	inm     []string
+	failing bool
+}
+
+func newCacheServer(t *testing.T) *cacheServer {
+	s := &cacheServer{hits: map[string]int{}}
+	s.testServer = newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits[r.URL.Path]++
+		hits, failing := s.hits[r.URL.Path], s.failing
+		if r.Header.Get("If-None-Match") != "" {
+			s.inm = append(s.inm, r.Header.Get("If-None-Match"))
+		}
+		s.mu.Unlock()
+		if failing {
+			w.WriteHeader(500)
+			return
+		}
+		if r.Method != "GET" {
+			return
+		}
+		w.Header().Set("Cache-Control", strings.TrimPrefix(r.URL.Path, "/cc/"))
+		w.Header().Set("ETag", `"e"`)
+		if r.URL.Query().Get("vary") != "" {
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		if r.Header.Get("If-None-Match") == `"e"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintf(w, "%d %s", hits, r.Header.Get("Accept-Language"))
+	})
+	return s
+}
+
+func (s *cacheServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+func (s *cacheServer) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+//cacheGet returns the content, the httpretcode and whether the response came from the cache.
+func cacheGet(c *Client, httpurl string, httpsendhead ...string) (string, int, bool) {
+	content, head, _, code, _ := c.UrlGet(httpurl, nil, false, httpsendhead, nil, time.Second, 5*time.Second, nil)
+	return string(content), code, head.Get("X-From-Cache") == "1"
+}
+
+func TestCacheFreshness(t *testing.T) {
+	srv := newCacheServer(t)
+	c := &Client{Cache: NewCache(NewMemoryCacheStorage(0))}
+
+	cacheGet(c, srv.URL+"/cc/max-age=60")
+	content, code, cached := cacheGet(c, srv.URL+"/cc/max-age=60")
+	if content != "1 " || code != 200 || !cached || srv.count("/cc/max-age=60") != 1 {
+		t.Errorf("fresh: %q httpretcode %d cached %v, %d requests", content, code, cached, srv.count("/cc/max-age=60"))
+	}
+	//the request can ask for a fresher response, which is revalidated
+	if _, _, cached = cacheGet(c, srv.URL+"/cc/max-age=60", "Cache-Control", "no-cache"); !cached || srv.count("/cc/max-age=60") != 2 || len(srv.inm) != 1 {
+		t.Errorf("request no-cache: cached %v, %d requests, If-None-Match %v", cached, srv.count("/cc/max-age=60"), srv.inm)
+	}
+	if cacheGet(c, srv.URL+"/cc/max-age=60", "Cache-Control", "min-fresh=120"); srv.count("/cc/max-age=60") != 3 {
+		t.Errorf("request min-fresh: %d requests", srv.count("/cc/max-age=60"))
+	}
+
+	cacheGet(c, srv.URL+"/cc/no-store")
+	if content, _, cached = cacheGet(c, srv.URL+"/cc/no-store"); cached || content != "2 " {
+		t.Errorf("no-store: %q cached %v", content, cached)
+	}
+	cacheGet(c, srv.URL+"/cc/max-age=30?vary=1", "Accept-Language", "en")
+	if content, _, cached = cacheGet(c, srv.URL+"/cc/max-age=30?vary=1", "Accept-Language", "fr"); cached || content != "2 fr" {
+		t.Errorf("another Vary value: %q cached %v", content, cached)
+	}
+	if content, _, cached = cacheGet(c, srv.URL+"/cc/max-age=30?vary=1", "Accept-Language", "fr"); !cached || content != "2 fr" {
+		t.Errorf("same Vary value: %q cached %v", content, cached)
+	}
+	//a Range bypasses the cache
+	if _, _, cached = cacheGet(c, srv.URL+"/cc/max-age=60", "Range", "bytes=0-0"); cached {
+		t.Error("a range request was served from the cache")
+	}
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	srv := newCacheServer(t)
+	c := &Client{Cache: NewCache(NewMemoryCacheStorage(0))}
+
+	cacheGet(c, srv.URL+"/cc/max-age=0")
+	content, code, cached := cacheGet(c, srv.URL+"/cc/max-age=0")
+	if content != "1 " || code != 200 || !cached || srv.count("/cc/max-age=0") != 2 || len(srv.inm) != 1 || srv.inm[0] != `"e"` {
+		t.Errorf("revalidated: %q httpretcode %d cached %v, %d requests, If-None-Match %v", content, code, cached, srv.count("/cc/max-age=0"), srv.inm)
+	}
+
+	//an unsafe method invalidates the stored response
+	cacheGet(c, srv.URL+"/cc/max-age=60")
+	c.UrlPost(srv.URL+"/cc/max-age=60", []string{"a", "b"}, false, nil, nil, time.Second, 5*time.Second)
+	if content, _, cached = cacheGet(c, srv.URL+"/cc/max-age=60"); cached {
+		t.Errorf("after POST: %q cached %v", content, cached)
+	}
+
+	if content, code, _ = cacheGet(c, srv.URL+"/cc/none", "Cache-Control", "only-if-cached"); code != 504 {
+		t.Errorf("only-if-cached miss: %q httpretcode %d", content, code)
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	srv := newCacheServer(t)
+	c := &Client{Cache: NewCache(NewMemoryCacheStorage(0))}
+
+	//stale-while-revalidate answers at once and revalidates in the background
+	path := "/cc/max-age=0,stale-while-revalidate=60"
+	cacheGet(c, srv.URL+path)
+	if content, _, cached := cacheGet(c, srv.URL+path); !cached || content != "1 " {
+		t.Errorf("stale-while-revalidate: %q cached %v", content, cached)
+	}
+	deadline := time.Now().Add(time.Second)
+	for srv.count(path) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if srv.count(path) != 2 {
+		t.Errorf("background revalidation: %d requests", srv.count(path))
+	}
+
+	//stale-if-error serves the stored response when the origin fails, must-revalidate forbids it
+	cacheGet(c, srv.URL+"/cc/max-age=0,stale-if-error=60")
+	cacheGet(c, srv.URL+"/cc/max-age=0,must-revalidate,stale-if-error=60")
+	srv.setFailing(true)
+	if content, code, cached := cacheGet(c, srv.URL+"/cc/max-age=0,stale-if-error=60"); code != 200 || !cached || content != "1 " {
+		t.Errorf("stale-if-error: %q httpretcode %d cached %v", content, code, cached)
+	}
+	if _, code, _ := cacheGet(c, srv.URL+"/cc/max-age=0,must-revalidate,stale-if-error=60"); code != 500 {
+		t.Errorf("must-revalidate: httpretcode %d", code)
+	}
+}
+
+func TestCacheShared(t *testing.T) {
+	srv := newCacheServer(t)
+	shared := &Client{Cache: &Cache{Storage: NewMemoryCacheStorage(0), Shared: true}}
+	for _, tc := range []struct {
+		path, auth string
+		cached     bool
+	}{
+		{"/cc/max-age=60", "Bearer t", false},
+		{"/cc/public,max-age=60", "Bearer t", true},
+		{"/cc/private,max-age=60", "", false},
+		{"/cc/max-age=0,s-maxage=60", "", true},
+	} {
+		cacheGet(shared, srv.URL+tc.path, "Authorization", tc.auth)
+		if _, _, cached := cacheGet(shared, srv.URL+tc.path, "Authorization", tc.auth); cached != tc.cached {
+			t.Errorf("shared %s with Authorization %q: cached %v", tc.path, tc.auth, cached)
+		}
+	}
+	//a private cache stores private responses
+	private := &Client{Cache: NewCache(NewMemoryCacheStorage(0))}
+	cacheGet(private, srv.URL+"/cc/private,max-age=60")
+	if _, _, cached := cacheGet(private, srv.URL+"/cc/private,max-age=60"); !cached {
+		t.Error("private cache: private response not stored")
+	}
+}
+
+func TestCacheStorage(t *testing.T) {
+	srv := newCacheServer(t)
+	//a body beyond MaxEntrySize is not stored
+	c := &Client{Cache: &Cache{Storage: NewMemoryCacheStorage(0), MaxEntrySize: 1}}
+	cacheGet(c, srv.URL+"/cc/max-age=60")
+	if _, _, cached := cacheGet(c, srv.URL+"/cc/max-age=60"); cached {
+		t.Error("a body beyond MaxEntrySize was stored")
+	}
+
+	m := NewMemoryCacheStorage(10)
+	m.Set("a", []byte("12345"))
+	m.Set("b", []byte("12345"))
+	m.Get("a")
+	m.Set("c", []byte("1"))
+	if _, ok := m.Get("b"); ok {
+		t.Error("the least recently used entry was kept")
+	}
+	if _, ok := m.Get("a"); !ok {
+		t.Error("a recently used entry was evicted")
+	}
+
+	//a disk storage is shared by caches
+	dir := t.TempDir()
+	d1, err := NewDiskCacheStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, _ := NewDiskCacheStorage(dir)
+	cacheGet(&Client{Cache: NewCache(d1)}, srv.URL+"/cc/max-age=300")
+	if content, _, cached := cacheGet(&Client{Cache: NewCache(d2)}, srv.URL+"/cc/max-age=300"); !cached || content != "1 " {
+		t.Errorf("disk storage: %q cached %v", content, cached)
+	}
+	NewCache(d2).Invalidate(srv.URL + "/cc/max-age=300")
+	if _, ok := d1.Get("GET " + srv.URL + "/cc/max-age=300"); ok {
+		t.Error("Invalidate kept the entry")
+	}
+}
+
+//TestCacheRevalidateCopy checks a 304 stores an updated copy and leaves the entry revalidated alone,
+//a caller may still be serving it while the revalidation runs in the background.
+func TestCacheRevalidateCopy(t *testing.T) {
+	srv := newCacheServer(t)
+	cache := NewCache(NewMemoryCacheStorage(0))
+	cacheGet(&Client{Cache: cache}, srv.URL+"/cc/max-age=0")
+	req, _ := http.NewRequest("GET", srv.URL+"/cc/max-age=0", nil)
+	key := cacheKey(req)
+	e := cache.load(key, req)
+	header, responsetime := e.Header.Clone(), e.ResponseTime
+
+	time.Sleep(10 * time.Millisecond)
+	resp, err := (&cacheTransport{cache: cache, next: http.DefaultTransport}).revalidate(req, key, e)
+	if err != nil || resp.Header.Get("X-From-Cache") != "1" {
+		t.Fatalf("revalidation: %v %v", resp, err)
+	}
+	resp.Body.Close()
+	if !e.ResponseTime.Equal(responsetime) || len(e.Header) != len(header) || e.Header.Get("Date") != header.Get("Date") {
+		t.Errorf("the revalidated entry was changed: %v %v", e.ResponseTime, e.Header)
+	}
+	if stored := cache.load(key, req); stored == nil || !stored.ResponseTime.After(responsetime) {
+		t.Error("the updated entry was not stored")
+	}
+}
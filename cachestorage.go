@@ -0,0 +1,125 @@
+// netutil project cachestorage.go
+package netutil
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//CacheStorage stores the encoded entries of a Cache.
+type CacheStorage interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+//memoryCacheStorage is a CacheStorage evicting the least recently used entries beyond maxbytes.
+type memoryCacheStorage struct {
+	mu       sync.Mutex
+	maxbytes int64
+	size     int64
+	lru      *list.List //front is the most recently used
+	items    map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	value []byte
+}
+
+//NewMemoryCacheStorage returns an in-memory LRU CacheStorage of at most maxbytes (0 means 64MB).
+func NewMemoryCacheStorage(maxbytes int64) CacheStorage {
+	if maxbytes <= 0 {
+		maxbytes = 64 << 20
+	}
+	return &memoryCacheStorage{maxbytes: maxbytes, lru: list.New(), items: map[string]*list.Element{}}
+}
+
+func (s *memoryCacheStorage) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(e)
+	return e.Value.(*memoryCacheItem).value, true
+}
+
+func (s *memoryCacheStorage) Set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	if int64(len(value)) > s.maxbytes {
+		return
+	}
+	s.items[key] = s.lru.PushFront(&memoryCacheItem{key, value})
+	s.size += int64(len(value))
+	for s.size > s.maxbytes {
+		s.remove(s.lru.Back().Value.(*memoryCacheItem).key)
+	}
+}
+
+func (s *memoryCacheStorage) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+func (s *memoryCacheStorage) remove(key string) {
+	if e, ok := s.items[key]; ok {
+		s.size -= int64(len(e.Value.(*memoryCacheItem).value))
+		s.lru.Remove(e)
+		delete(s.items, key)
+	}
+}
+
+//diskCacheStorage is a CacheStorage with one file per entry in a directory.
+type diskCacheStorage struct {
+	dir string
+}
+
+//NewDiskCacheStorage returns a CacheStorage in dir, created if missing. Entries are files named by
+//the SHA-256 of their key and are written atomically, several processes may share dir.
+func NewDiskCacheStorage(dir string) (CacheStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &diskCacheStorage{dir: dir}, nil
+}
+
+func (s *diskCacheStorage) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *diskCacheStorage) Get(key string) ([]byte, bool) {
+	value, err := ioutil.ReadFile(s.path(key))
+	return value, err == nil
+}
+
+func (s *diskCacheStorage) Set(key string, value []byte) {
+	tmp, err := ioutil.TempFile(s.dir, ".tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(value)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+func (s *diskCacheStorage) Delete(key string) {
+	os.Remove(s.path(key))
+}
@@ -0,0 +1,144 @@
+// netutil project checksum.go
+package netutil
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+//Checksum is an expected digest of a download.
+type Checksum struct {
+	//Algorithm is "md5", "sha1", "sha256" or "sha512".
+	Algorithm string
+	Sum       []byte
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+//ParseChecksum parses "algorithm:digest", the digest in hex or base64, e.g. "sha256:9f86d0...".
+//"sha-256" and "SHA256" are accepted for "sha256".
+func ParseChecksum(s string) (*Checksum, error) {
+	i := strings.IndexAny(s, ":=")
+	if i < 0 {
+		return nil, errors.New("checksum without algorithm: " + s)
+	}
+	algorithm := strings.Replace(strings.ToLower(s[:i]), "-", "", 1)
+	h := newHash(algorithm)
+	if h == nil {
+		return nil, errors.New("unsupported checksum algorithm " + s[:i])
+	}
+	digest := strings.TrimSpace(s[i+1:])
+	sum, err := hex.DecodeString(digest)
+	if err != nil || len(sum) != h.Size() {
+		sum, err = base64.StdEncoding.DecodeString(digest)
+	}
+	if err != nil || len(sum) != h.Size() {
+		return nil, errors.New("bad " + algorithm + " digest " + digest)
+	}
+	return &Checksum{Algorithm: algorithm, Sum: sum}, nil
+}
+
+func (c *Checksum) String() string {
+	return c.Algorithm + ":" + hex.EncodeToString(c.Sum)
+}
+
+//HeaderChecksums returns the digests a response declares in Content-MD5, Digest (RFC 3230) and
+//Repr-Digest (RFC 9530) for the algorithms of Checksum, other algorithms are ignored.
+//They cover the body as sent, before any Content-Encoding is decoded.
+func HeaderChecksums(h http.Header) []*Checksum {
+	var checksums []*Checksum
+	add := func(algorithm, digest string) {
+		algorithm = strings.Replace(strings.ToLower(strings.TrimSpace(algorithm)), "-", "", 1)
+		if algorithm == "sha" {
+			algorithm = "sha1"
+		}
+		hs := newHash(algorithm)
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(digest))
+		if hs != nil && err == nil && len(sum) == hs.Size() {
+			checksums = append(checksums, &Checksum{Algorithm: algorithm, Sum: sum})
+		}
+	}
+	if v := h.Get("Content-MD5"); v != "" {
+		add("md5", v)
+	}
+	for _, field := range []string{"Repr-Digest", "Digest"} {
+		for _, v := range h.Values(field) {
+			for _, item := range strings.Split(v, ",") {
+				i := strings.Index(item, "=")
+				if i < 0 {
+					continue
+				}
+				//Repr-Digest values are byte sequences, sha-256=:base64:
+				add(item[:i], strings.Trim(strings.TrimSpace(item[i+1:]), ":"))
+			}
+		}
+	}
+	return checksums
+}
+
+//ChecksumError reports content not matching its expected digest, the httpretcode is 8 then.
+type ChecksumError struct {
+	URL       string
+	Algorithm string
+	Want      []byte
+	Got       []byte
+	//FromHeader is true when the expected digest came from a response header.
+	FromHeader bool
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: %s checksum mismatch: want %x, got %x", e.URL, e.Algorithm, e.Want, e.Got)
+}
+
+//checksumWriter hashes the bytes written for every checksum.
+type checksumWriter struct {
+	checksums []*Checksum
+	hashes    []hash.Hash
+}
+
+func newChecksumWriter(checksums []*Checksum) *checksumWriter {
+	w := &checksumWriter{checksums: checksums}
+	for _, sum := range checksums {
+		w.hashes = append(w.hashes, newHash(sum.Algorithm))
+	}
+	return w
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	for _, h := range w.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+//mismatch returns the error for the first checksum not matching, nil if all match.
+func (w *checksumWriter) mismatch(httpurl string, fromheader bool) *ChecksumError {
+	for i, h := range w.hashes {
+		if got := h.Sum(nil); !bytes.Equal(got, w.checksums[i].Sum) {
+			return &ChecksumError{URL: httpurl, Algorithm: w.checksums[i].Algorithm, Want: w.checksums[i].Sum, Got: got, FromHeader: fromheader}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// netutil project checksum_test.go
+package netutil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("abc"))
+	hexsum := fmt.Sprintf("%x", sum)
+	b64sum := base64.StdEncoding.EncodeToString(sum[:])
+	for _, s := range []string{"sha256:" + hexsum, "SHA-256:" + hexsum, "sha256=" + b64sum, "Sha256: " + hexsum} {
+		c, err := ParseChecksum(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if c.Algorithm != "sha256" || !bytes.Equal(c.Sum, sum[:]) || c.String() != "sha256:"+hexsum {
+			t.Errorf("%s: parsed %v", s, c)
+		}
+	}
+	for _, s := range []string{hexsum, "crc32:00000000", "sha256:" + hexsum[:10], "md5:" + hexsum, "sha1:zz"} {
+		if _, err := ParseChecksum(s); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+}
+
+func TestHeaderChecksums(t *testing.T) {
+	body := []byte("abc")
+	md5sum := md5.Sum(body)
+	sha1sum := sha1.Sum(body)
+	sha256sum := sha256.Sum256(body)
+	b64 := func(b []byte) string { return base64.StdEncoding.EncodeToString(b) }
+	h := http.Header{}
+	h.Set("Content-MD5", b64(md5sum[:]))
+	h.Set("Repr-Digest", "sha-256=:"+b64(sha256sum[:])+":, unknown=:AAAA:")
+	h.Set("Digest", "SHA="+b64(sha1sum[:])+",crc32c=AAAAAA==")
+	var got []string
+	for _, c := range HeaderChecksums(h) {
+		got = append(got, c.String())
+	}
+	want := []string{"md5:" + fmt.Sprintf("%x", md5sum), "sha256:" + fmt.Sprintf("%x", sha256sum), "sha1:" + fmt.Sprintf("%x", sha1sum)}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("HeaderChecksums %v, want %v", got, want)
+	}
+	if sums := HeaderChecksums(http.Header{"Digest": {"sha-256=notbase64"}}); len(sums) != 0 {
+		t.Errorf("a bad digest was parsed: %v", sums)
+	}
+}
+
+//newDigestServer answers body with the Digest header digest, gzip encoded when gz is set,
+//the digest covering the encoded body.
+func newDigestServer(t *testing.T, body []byte, digest string, gz bool) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if digest != "" {
+			w.Header().Set("Digest", digest)
+		}
+		if gz {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Write(body)
+	})
+}
+
+func TestUrlGetChecksum(t *testing.T) {
+	body := []byte("payload")
+	sum := sha256.Sum256(body)
+	md5sum := md5.Sum(body)
+	srv := newDigestServer(t, body, "", false)
+	good := fmt.Sprintf("sha256:%x, md5:%x", sum, md5sum)
+
+	content, _, _, code, _, err := UrlGetChecksum(srv.URL, nil, nil, nil, good, time.Second, 5*time.Second)
+	if code != 200 || err != nil || string(content) != "payload" {
+		t.Errorf("matching: httpretcode %d %q %v", code, content, err)
+	}
+	content, _, _, code, _, err = UrlGetChecksum(srv.URL, nil, nil, nil, fmt.Sprintf("sha256:%x, md5:%x", sum, md5.Sum(nil)), time.Second, 5*time.Second)
+	var cerr *ChecksumError
+	if code != 8 || len(content) != 0 || !errors.As(err, &cerr) || cerr.Algorithm != "md5" || cerr.FromHeader {
+		t.Errorf("mismatch: httpretcode %d %q %v", code, content, err)
+	}
+	if _, _, _, code, _, err = UrlGetChecksum(srv.URL, nil, nil, nil, "sha256:xyz", time.Second, 5*time.Second); code != 3 || err == nil {
+		t.Errorf("bad checksum: httpretcode %d %v", code, err)
+	}
+}
+
+func TestUrlGetToFileChecksum(t *testing.T) {
+	body := []byte("payload")
+	sum := sha256.Sum256(body)
+	wrong := sha256.Sum256([]byte("other"))
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "out.bin")
+
+	srv := newDigestServer(t, body, "", false)
+	if _, _, code, _, err := UrlGetToFileChecksum(srv.URL, nil, nil, nil, dst, fmt.Sprintf("sha256:%x", wrong), time.Second, 5*time.Second); code != 8 || err == nil {
+		t.Errorf("mismatch: httpretcode %d %v", code, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("a mismatch left %d files", len(files))
+	}
+	if _, _, code, _, err := UrlGetToFileChecksum(srv.URL, nil, nil, nil, dst, fmt.Sprintf("sha256:%x", sum), time.Second, 5*time.Second); code != 200 || err != nil {
+		t.Errorf("matching: httpretcode %d %v", code, err)
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "payload" {
+		t.Errorf("file content %q", b)
+	}
+
+	//the digest of a response header is verified without a checksum argument
+	bad := newDigestServer(t, body, "sha-256="+base64.StdEncoding.EncodeToString(wrong[:]), false)
+	_, _, code, _, err := UrlGetToFileChecksum(bad.URL, nil, nil, nil, dst, "", time.Second, 5*time.Second)
+	var cerr *ChecksumError
+	if code != 8 || !errors.As(err, &cerr) || !cerr.FromHeader {
+		t.Errorf("header mismatch: httpretcode %d %v", code, err)
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "payload" {
+		t.Errorf("a mismatch replaced the file: %q", b)
+	}
+}
+
+//TestHeaderChecksumEncoded checks a header digest covers the body as sent while a checksum
+//argument covers the decoded content.
+func TestHeaderChecksumEncoded(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("payload"))
+	zw.Close()
+	rawsum := sha256.Sum256(gz.Bytes())
+	sum := sha256.Sum256([]byte("payload"))
+	srv := newDigestServer(t, gz.Bytes(), "sha-256="+base64.StdEncoding.EncodeToString(rawsum[:]), true)
+
+	content, _, _, code, _, err := UrlGetChecksum(srv.URL, nil, []string{"Accept-Encoding", "gzip"}, nil, fmt.Sprintf("sha256:%x", sum), time.Second, 5*time.Second)
+	if code != 200 || err != nil || string(content) != "payload" {
+		t.Errorf("httpretcode %d %q %v", code, content, err)
+	}
+}
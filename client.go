@@ -0,0 +1,186 @@
+// netutil project client.go
+package netutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//Client holds the optional settings used by its Url* methods, the package level
+//Url* functions use DefaultClient. Copy a Client to change a setting for some requests only.
+type Client struct {
+	//Progress is called while request and response bodies are transferred.
+	Progress ProgressFunc
+	//ProgressInterval is the minimum time between two Progress calls, 0 means 500ms.
+	ProgressInterval time.Duration
+	//Proxy selects the proxy of each request, nil means HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	//from the environment, use NoProxy to connect directly.
+	Proxy ProxyFunc
+	//ProxyPool, when not nil, selects the proxy instead of Proxy and is told the outcome of each request.
+	ProxyPool *ProxyPool
+	//TLS configures root CAs, client certificates and versions of https connections, nil uses the defaults.
+	TLS *TLSOptions
+	//Redirect controls following redirects, nil follows at most 10.
+	Redirect *RedirectPolicy
+	//Timeouts sets separate dial, TLS, header, request and idle timeouts instead of the absolute
+	//data deadline of the Url* functions, nil keeps contimeout and datatrantimeout as they are.
+	Timeouts *Timeouts
+	//RateLimit limits the bytes per second of the request and response bodies of this Client, in addition to GlobalRateLimit.
+	RateLimit *RateLimiter
+	//HostLimit, when not nil, limits the request rate and concurrency per host.
+	HostLimit *HostLimiter
+	//Breaker, when not nil, fails requests to hosts with an open circuit with ErrCircuitOpen, share it between Clients.
+	Breaker *CircuitBreaker
+	//Hedge, when not nil, sends hedged copies of slow idempotent requests.
+	Hedge *HedgePolicy
+	//Validators stores the ETag and Last-Modified of the *IfModified functions, UrlGetIfModified needs it
+	//to send conditional requests, nil stores nothing.
+	Validators ValidatorStore
+	//Cache, when not nil, serves GET requests from an RFC 9111 cache.
+	Cache *Cache
+	//SingleFlight, when not nil, lets concurrent identical GET requests share one request.
+	SingleFlight *SingleFlight
+	//OnResponse, when not nil, is called with the meta data of each request when its response headers arrived or it failed.
+	OnResponse func(meta *ResponseMeta)
+
+	ctx context.Context
+}
+
+//ResponseMeta describes how a request was served.
+type ResponseMeta struct {
+	//Request is the last request sent, after redirects.
+	Request *http.Request
+	//Proxy served the last request, nil for a direct connection.
+	Proxy *url.URL
+	//Redirects are the redirects followed to reach Request, in order.
+	Redirects  []RedirectHop
+	StatusCode int
+	Err        error
+}
+
+var DefaultClient = &Client{}
+
+//WithContext returns a copy of c whose requests use ctx, canceling ctx aborts them
+//including the waits of HostLimit.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+//do sends request with client, applying the settings of c to the request and response bodies.
+func (c *Client) do(client *httpClient, request *http.Request) (*http.Response, error) {
+	if c.ctx != nil {
+		request = request.WithContext(c.ctx)
+	}
+	ctx, proxy := withRequestProxy(request.Context())
+	request = request.WithContext(ctx)
+	limiters := c.rateLimiters()
+	if len(limiters) > 0 && request.Body != nil && request.Body != http.NoBody {
+		request.Body = &throttledReader{ctx, request.Body, limiters}
+	}
+	if c.Progress != nil && request.Body != nil && request.Body != http.NoBody {
+		total := request.ContentLength
+		if total <= 0 {
+			total = -1
+		}
+		request.Body = newProgressReader(request.Body, total, true, c.Progress, c.ProgressInterval)
+	}
+	response, err := client.Do(request)
+	meta := &ResponseMeta{Request: request, Proxy: proxy.get(), Redirects: client.redirectChain(), Err: err}
+	if response != nil {
+		meta.Request = response.Request
+		meta.StatusCode = response.StatusCode
+	}
+	if c.ProxyPool != nil && meta.Proxy != nil {
+		//only failures of the proxy count, not those of the target or a canceled request
+		if proxy.proxyFailed() || meta.StatusCode == http.StatusProxyAuthRequired {
+			c.ProxyPool.report(meta.Proxy, false)
+		} else if err == nil {
+			c.ProxyPool.report(meta.Proxy, true)
+		}
+	}
+	if c.OnResponse != nil {
+		c.OnResponse(meta)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(limiters) > 0 {
+		response.Body = &throttledReader{ctx, response.Body, limiters}
+	}
+	if c.Progress != nil {
+		response.Body = newProgressReader(response.Body, response.ContentLength, false, c.Progress, c.ProgressInterval)
+	}
+	return response, nil
+}
+
+//httpgetdata format name follow value sequence.
+func UrlGet(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration, outbuf []byte, getctt_contenttype_regex ...string) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+	return DefaultClient.UrlGet(httpurl, httpgetdata, onlyhead, httpsendhead, cookie, contimeout, datatrantimeout, outbuf, getctt_contenttype_regex...)
+}
+
+func UrlPost(httpurl string, postdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+	return DefaultClient.UrlPost(httpurl, postdata, onlyhead, httpsendhead, cookie, contimeout, datatrantimeout)
+}
+
+//multi file upload in one segment need add postfield name with "[]"
+//any value which is an existing file path is uploaded as file.
+//
+//Deprecated: use UrlPostMultipart to declare file parts explicitly.
+func UrlPostWithFile(httpurl string, postdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+	return DefaultClient.UrlPostWithFile(httpurl, postdata, onlyhead, httpsendhead, cookie, contimeout, datatrantimeout)
+}
+
+//UrlPostMultipart posts body as multipart/form-data, the returns are the same as UrlPostWithFile.
+func UrlPostMultipart(httpurl string, body *Multipart, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+	return DefaultClient.UrlPostMultipart(httpurl, body, onlyhead, httpsendhead, cookie, contimeout, datatrantimeout)
+}
+
+func UrlPostDir(httpurl string, name, dir string, include, exclude []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+	return DefaultClient.UrlPostDir(httpurl, name, dir, include, exclude, onlyhead, httpsendhead, cookie, contimeout, datatrantimeout)
+}
+
+func UrlGetToFile(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, filepath string, contimeout, datatrantimeout time.Duration) (head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+	return DefaultClient.UrlGetToFile(httpurl, httpgetdata, onlyhead, httpsendhead, cookie, filepath, contimeout, datatrantimeout)
+}
+
+func UrlGetToFileMirrors(mirrors []string, checksum string, probetimeout time.Duration, httpsendhead []string, cookie []*http.Cookie, filepath string, contimeout, datatrantimeout time.Duration) (head http.Header, mirror string, httpretcode int, attempts []MirrorAttempt) {
+	return DefaultClient.UrlGetToFileMirrors(mirrors, checksum, probetimeout, httpsendhead, cookie, filepath, contimeout, datatrantimeout)
+}
+
+func UrlGetToFileChecksum(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, filepath string, checksum string, contimeout, datatrantimeout time.Duration) (head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	return DefaultClient.UrlGetToFileChecksum(httpurl, httpgetdata, httpsendhead, cookie, filepath, checksum, contimeout, datatrantimeout)
+}
+
+func UrlGetChecksum(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, checksum string, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	return DefaultClient.UrlGetChecksum(httpurl, httpgetdata, httpsendhead, cookie, checksum, contimeout, datatrantimeout)
+}
+
+func UrlGetMetalink(metaurl string, dir string, locations []string, connections int, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (paths []string, httpretcode int, err error) {
+	return DefaultClient.UrlGetMetalink(metaurl, dir, locations, connections, httpsendhead, cookie, contimeout, datatrantimeout)
+}
+
+func UrlGetToDir(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, dir string, collision int, contimeout, datatrantimeout time.Duration) (savedpath string, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	return DefaultClient.UrlGetToDir(httpurl, httpgetdata, httpsendhead, cookie, dir, collision, contimeout, datatrantimeout)
+}
+
+func UrlGetToWriter(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, w io.Writer, contimeout, datatrantimeout time.Duration) (written int64, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	return DefaultClient.UrlGetToWriter(httpurl, httpgetdata, httpsendhead, cookie, w, contimeout, datatrantimeout)
+}
+
+func UrlGetToWriterAt(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, w io.WriterAt, segments int, contimeout, datatrantimeout time.Duration) (size int64, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	return DefaultClient.UrlGetToWriterAt(httpurl, httpgetdata, httpsendhead, cookie, w, segments, contimeout, datatrantimeout)
+}
+
+//DefaultClient stores no validators, only the modification time of filepath is sent.
+func UrlGetToFileIfModified(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, filepath string, contimeout, datatrantimeout time.Duration) (unchanged bool, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	return DefaultClient.UrlGetToFileIfModified(httpurl, httpgetdata, httpsendhead, cookie, filepath, contimeout, datatrantimeout)
+}
+
+func UrlGetWithRange(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, startpos, endpos int64, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+	return DefaultClient.UrlGetWithRange(httpurl, httpgetdata, onlyhead, httpsendhead, cookie, startpos, endpos, contimeout, datatrantimeout)
+}
@@ -0,0 +1,217 @@
+// netutil project conditional.go
+package netutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//Validators are the ETag and Last-Modified of a downloaded resource.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+//ValidatorStore keeps the Validators of UrlGetIfModified and UrlGetToFileIfModified, set it as
+//Client.Validators. Keys are urls, prefixed by the absolute file path for UrlGetToFileIfModified.
+type ValidatorStore interface {
+	Get(key string) (Validators, bool)
+	//Set stores v, zero Validators remove key.
+	Set(key string, v Validators)
+}
+
+type memoryValidators struct {
+	mu sync.Mutex
+	m  map[string]Validators
+}
+
+//NewMemoryValidators returns a ValidatorStore in memory.
+func NewMemoryValidators() ValidatorStore {
+	return &memoryValidators{m: map[string]Validators{}}
+}
+
+func (s *memoryValidators) Get(key string) (Validators, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.m[key]
+	return v, ok
+}
+
+func (s *memoryValidators) Set(key string, v Validators) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v == (Validators{}) {
+		delete(s.m, key)
+	} else {
+		s.m[key] = v
+	}
+}
+
+//FileValidators is a ValidatorStore kept as JSON in a file, written on every change, so
+//validators survive restarts of hourly jobs.
+type FileValidators struct {
+	path string
+	mu   sync.Mutex
+	m    map[string]Validators
+}
+
+//NewFileValidators loads the store in path, a missing file is an empty store.
+func NewFileValidators(path string) (*FileValidators, error) {
+	s := &FileValidators{path: path, m: map[string]Validators{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.m); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileValidators) Get(key string) (Validators, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.m[key]
+	return v, ok
+}
+
+func (s *FileValidators) Set(key string, v Validators) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.m[key]; ok && old == v || !ok && v == (Validators{}) {
+		return
+	}
+	if v == (Validators{}) {
+		delete(s.m, key)
+	} else {
+		s.m[key] = v
+	}
+	data, err := json.MarshalIndent(s.m, "", "\t")
+	if err != nil {
+		return
+	}
+	//write aside and rename, a crash never leaves a truncated store
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	os.Rename(tmp, s.path)
+}
+
+//noValidators is the store of Clients whose Validators is nil, it keeps nothing, so unrelated
+//callers never get a 304 for a content they do not have.
+type noValidators struct{}
+
+func (noValidators) Get(key string) (Validators, bool) {
+	return Validators{}, false
+}
+
+func (noValidators) Set(key string, v Validators) {}
+
+func (c *Client) validators() ValidatorStore {
+	if c.Validators != nil {
+		return c.Validators
+	}
+	return noValidators{}
+}
+
+//conditionalHead appends If-None-Match and If-Modified-Since for v to httpsendhead.
+func conditionalHead(httpsendhead []string, v Validators) []string {
+	head := append([]string(nil), httpsendhead...)
+	if v.ETag != "" {
+		head = append(head, "If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		head = append(head, "If-Modified-Since", v.LastModified)
+	}
+	return head
+}
+
+//responseValidators returns the validators of response, those of old a 304 does not repeat.
+func responseValidators(response *http.Response, old Validators) Validators {
+	v := Validators{ETag: response.Header.Get("ETag"), LastModified: response.Header.Get("Last-Modified")}
+	if response.StatusCode == http.StatusNotModified {
+		if v.ETag == "" {
+			v.ETag = old.ETag
+		}
+		if v.LastModified == "" {
+			v.LastModified = old.LastModified
+		}
+	}
+	return v
+}
+
+//UrlGetIfModified is UrlGet sending and storing the validators of httpurl in c.Validators, without them it
+//is a plain UrlGet. unchanged is true for a 304 Not Modified, content is empty then.
+func (c *Client) UrlGetIfModified(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, unchanged bool, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	httpurl, ok := appendQuery(httpurl, httpgetdata)
+	if !ok {
+		return []byte(""), false, http.Header{}, nil, 3, redilocation, nil
+	}
+	store := c.validators()
+	old, _ := store.Get(httpurl)
+	var buf bytes.Buffer
+	response, code, redilocation, err := c.fetch(httpurl, conditionalHead(httpsendhead, old), cookie, nil, false, contimeout, datatrantimeout, func(*http.Response) (io.Writer, error) {
+		return &buf, nil
+	})
+	if response == nil {
+		return []byte(""), false, http.Header{}, nil, code, redilocation, err
+	}
+	if err == nil && (code == http.StatusNotModified || code >= 200 && code < 300) {
+		store.Set(httpurl, responseValidators(response, old))
+	}
+	if err != nil || code < 200 || code >= 300 {
+		return []byte(""), code == http.StatusNotModified, response.Header, response.Cookies(), code, redilocation, err
+	}
+	return buf.Bytes(), false, response.Header, response.Cookies(), code, redilocation, nil
+}
+
+//UrlGetToFileIfModified downloads httpurl to filepath unless a 304 Not Modified answers the validators in
+//c.Validators or the modification time of filepath, then unchanged is true and filepath is kept.
+func (c *Client) UrlGetToFileIfModified(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, filepath string, contimeout, datatrantimeout time.Duration) (unchanged bool, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	httpurl, ok := appendQuery(httpurl, httpgetdata)
+	if !ok {
+		return false, http.Header{}, nil, 3, redilocation, nil
+	}
+	store := c.validators()
+	key := absPath(filepath) + " " + httpurl
+	var old Validators
+	if fi, err := os.Stat(filepath); err == nil {
+		var stored bool
+		if old, stored = store.Get(key); !stored {
+			old.LastModified = fi.ModTime().UTC().Format(http.TimeFormat)
+		}
+	}
+	response, code, redilocation, err := c.fetchToFile(httpurl, conditionalHead(httpsendhead, old), cookie, filepath, nil, false, contimeout, datatrantimeout)
+	if response == nil {
+		return false, http.Header{}, nil, code, redilocation, err
+	}
+	if err != nil || code != http.StatusNotModified && (code < 200 || code >= 300) {
+		return false, response.Header, response.Cookies(), code, redilocation, err
+	}
+	v := responseValidators(response, old)
+	store.Set(key, v)
+	if code == http.StatusNotModified {
+		return true, response.Header, response.Cookies(), code, redilocation, nil
+	}
+	if lastmodified, perr := http.ParseTime(v.LastModified); perr == nil {
+		os.Chtimes(filepath, time.Now(), lastmodified)
+	}
+	return false, response.Header, response.Cookies(), code, redilocation, nil
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
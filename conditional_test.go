@@ -0,0 +1,162 @@
+// netutil project conditional_test.go
+package netutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+//conditionalServer serves body under etag and modtime, answering 304 to matching validators.
+//It records the conditional headers it got.
+type conditionalServer struct {
+	*testServer
+	mu      sync.Mutex
+	body    string
+	etag    string
+	modtime time.Time
+	conds   []string
+}
+
+func newConditionalServer(t *testing.T) *conditionalServer {
+	s := &conditionalServer{body: "v1", etag: `"v1"`, modtime: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	s.testServer = newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.conds = append(s.conds, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+		body, etag, modtime := s.body, s.etag, s.modtime
+		s.mu.Unlock()
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modtime.Format(http.TimeFormat))
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			if inm == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modtime.After(ims) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(body))
+	})
+	return s
+}
+
+func (s *conditionalServer) change(body, etag string, modtime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body, s.etag, s.modtime = body, etag, modtime
+}
+
+//lastCond returns the conditional headers of the last request, "If-None-Match|If-Modified-Since".
+func (s *conditionalServer) lastCond() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conds[len(s.conds)-1]
+}
+
+func TestUrlGetIfModified(t *testing.T) {
+	srv := newConditionalServer(t)
+	store := NewMemoryValidators()
+	c := &Client{Validators: store}
+
+	content, unchanged, _, _, code, _, err := c.UrlGetIfModified(srv.URL, nil, nil, nil, time.Second, 5*time.Second)
+	if code != 200 || unchanged || string(content) != "v1" || err != nil || srv.lastCond() != "|" {
+		t.Fatalf("first: httpretcode %d unchanged %v %q %v, sent %q", code, unchanged, content, err, srv.lastCond())
+	}
+	if v, ok := store.Get(srv.URL); !ok || v.ETag != `"v1"` || v.LastModified != "Wed, 01 May 2024 12:00:00 GMT" {
+		t.Errorf("stored %+v %v", v, ok)
+	}
+	content, unchanged, _, _, code, _, _ = c.UrlGetIfModified(srv.URL, nil, nil, nil, time.Second, 5*time.Second)
+	if code != 304 || !unchanged || len(content) != 0 || srv.lastCond() != `"v1"|Wed, 01 May 2024 12:00:00 GMT` {
+		t.Errorf("unchanged: httpretcode %d unchanged %v %q, sent %q", code, unchanged, content, srv.lastCond())
+	}
+	srv.change("v2", `"v2"`, srv.modtime.Add(time.Hour))
+	content, unchanged, _, _, code, _, _ = c.UrlGetIfModified(srv.URL, nil, nil, nil, time.Second, 5*time.Second)
+	if code != 200 || unchanged || string(content) != "v2" {
+		t.Errorf("changed: httpretcode %d unchanged %v %q", code, unchanged, content)
+	}
+	if v, _ := store.Get(srv.URL); v.ETag != `"v2"` {
+		t.Errorf("stored %+v after the change", v)
+	}
+}
+
+//TestUrlGetIfModifiedNoStore checks calls without Client.Validators never send validators,
+//a caller must not get a 304 for a content it never downloaded.
+func TestUrlGetIfModifiedNoStore(t *testing.T) {
+	srv := newConditionalServer(t)
+	for i := 0; i < 2; i++ {
+		content, unchanged, _, _, code, _, _ := (&Client{}).UrlGetIfModified(srv.URL, nil, nil, nil, time.Second, 5*time.Second)
+		if code != 200 || unchanged || string(content) != "v1" || srv.lastCond() != "|" {
+			t.Errorf("call %d: httpretcode %d unchanged %v %q, sent %q", i, code, unchanged, content, srv.lastCond())
+		}
+	}
+}
+
+func TestUrlGetToFileIfModified(t *testing.T) {
+	srv := newConditionalServer(t)
+	dst := filepath.Join(t.TempDir(), "out.txt")
+
+	//without a store the modification time of the file is the validator
+	unchanged, _, _, code, _, err := UrlGetToFileIfModified(srv.URL, nil, nil, nil, dst, time.Second, 5*time.Second)
+	if code != 200 || unchanged || err != nil || srv.lastCond() != "|" {
+		t.Fatalf("first: httpretcode %d unchanged %v %v, sent %q", code, unchanged, err, srv.lastCond())
+	}
+	if fi, err := os.Stat(dst); err != nil || !fi.ModTime().Equal(srv.modtime) {
+		t.Errorf("modification time %v %v, want the Last-Modified", fi.ModTime(), err)
+	}
+	unchanged, _, _, code, _, _ = UrlGetToFileIfModified(srv.URL, nil, nil, nil, dst, time.Second, 5*time.Second)
+	if code != 304 || !unchanged || srv.lastCond() != "|Wed, 01 May 2024 12:00:00 GMT" {
+		t.Errorf("unchanged: httpretcode %d unchanged %v, sent %q", code, unchanged, srv.lastCond())
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "v1" {
+		t.Errorf("content %q", b)
+	}
+
+	//with a store the ETag is sent too, keyed by the file
+	c := &Client{Validators: NewMemoryValidators()}
+	c.UrlGetToFileIfModified(srv.URL, nil, nil, nil, dst, time.Second, 5*time.Second)
+	unchanged, _, _, code, _, _ = c.UrlGetToFileIfModified(srv.URL, nil, nil, nil, dst, time.Second, 5*time.Second)
+	if code != 304 || !unchanged || srv.lastCond() != `"v1"|Wed, 01 May 2024 12:00:00 GMT` {
+		t.Errorf("store: httpretcode %d unchanged %v, sent %q", code, unchanged, srv.lastCond())
+	}
+	other := filepath.Join(filepath.Dir(dst), "other.txt")
+	if unchanged, _, _, code, _, _ = c.UrlGetToFileIfModified(srv.URL, nil, nil, nil, other, time.Second, 5*time.Second); code != 200 || unchanged {
+		t.Errorf("another file: httpretcode %d unchanged %v", code, unchanged)
+	}
+
+	//a missing file is downloaded unconditionally
+	os.Remove(dst)
+	if unchanged, _, _, code, _, _ = c.UrlGetToFileIfModified(srv.URL, nil, nil, nil, dst, time.Second, 5*time.Second); code != 200 || unchanged || srv.lastCond() != "|" {
+		t.Errorf("missing file: httpretcode %d unchanged %v, sent %q", code, unchanged, srv.lastCond())
+	}
+}
+
+func TestFileValidators(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "validators.json")
+	s, err := NewFileValidators(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("a", Validators{ETag: `"1"`})
+	s.Set("b", Validators{LastModified: "Wed, 01 May 2024 12:00:00 GMT"})
+	s.Set("b", Validators{})
+
+	s, err = NewFileValidators(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := s.Get("a"); !ok || v.ETag != `"1"` {
+		t.Errorf("reloaded a: %+v %v", v, ok)
+	}
+	if _, ok := s.Get("b"); ok {
+		t.Error("removed b was reloaded")
+	}
+	writeTestFile(t, path, "{broken")
+	if _, err := NewFileValidators(path); err == nil {
+		t.Error("a broken store was loaded")
+	}
+}
@@ -0,0 +1,284 @@
+// netutil project download.go
+package netutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//uncompressReader decodes body by the Content-Encoding name while reading.
+func uncompressReader(body io.Reader, name string) (io.ReadCloser, error) {
+	switch strings.ToLower(name) {
+	case "", "identity":
+		return ioutil.NopCloser(body), nil
+	case "gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		return flate.NewReader(body), nil
+	}
+	return nil, errors.New("unknow compress method")
+}
+
+//appendQuery adds httpgetdata, name follow value sequence, to the query of httpurl.
+func appendQuery(httpurl string, httpgetdata []string) (string, bool) {
+	if len(httpgetdata)%2 != 0 {
+		return httpurl, false
+	}
+	urlparam := url.Values{}
+	for i := 0; i < len(httpgetdata); i += 2 {
+		urlparam.Set(httpgetdata[i], httpgetdata[i+1])
+	}
+	if urlparamstr := urlparam.Encode(); len(urlparamstr) > 0 {
+		if strings.Index(httpurl, "?") == -1 {
+			httpurl += "?" + urlparamstr
+		} else {
+			httpurl += "&" + urlparamstr
+		}
+	}
+	return httpurl, true
+}
+
+//errWriter keeps the error of w, telling it from an error reading the response.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+//fetch GETs httpurl and, when the status is 2xx, copies the decoded body to the writer returned by open
+//while hashing it for checksums and, if fromheader is set, the raw body for the digests of the response
+//headers. The response is returned with its body closed, err explains a httpretcode which is not
+//the status code: 5 uncompress error, 6 writer error, 8 checksum mismatch (*ChecksumError), 9 open
+//returned ErrFileExists.
+func (c *Client) fetch(httpurl string, httpsendhead []string, cookie []*http.Cookie, checksums []*Checksum, fromheader bool, contimeout, datatrantimeout time.Duration, open func(response *http.Response) (io.Writer, error)) (response *http.Response, httpretcode int, redilocation string, err error) {
+	client := c.newHttpClient(contimeout, datatrantimeout, &redilocation)
+	defer client.CloseIdleConnections()
+	request, err := http.NewRequest("GET", httpurl, nil)
+	if err != nil {
+		return nil, 1, redilocation, err
+	}
+	setRequestHead(request, "", httpsendhead, cookie)
+	response, err = c.do(client, request)
+	if err != nil {
+		return nil, doErrCode(err), redilocation, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response, response.StatusCode, redilocation, nil
+	}
+
+	var raw io.Reader = response.Body
+	var headersums *checksumWriter
+	//header digests cover the whole representation as sent, they cannot be checked after
+	//the transport decoded it or for a part of it
+	if fromheader && !response.Uncompressed && response.StatusCode == http.StatusOK {
+		if hs := HeaderChecksums(response.Header); len(hs) > 0 {
+			headersums = newChecksumWriter(hs)
+			raw = io.TeeReader(raw, headersums)
+		}
+	}
+	body, err := uncompressReader(raw, response.Header.Get("Content-Encoding"))
+	if err != nil {
+		return response, 5, redilocation, err
+	}
+	defer body.Close()
+	w, err := open(response)
+	if err == ErrFileExists {
+		return response, 9, redilocation, err
+	} else if err != nil {
+		return response, 6, redilocation, err
+	}
+	sums := newChecksumWriter(checksums)
+	dest := &errWriter{w: w}
+	if _, err = io.Copy(io.MultiWriter(dest, sums), body); dest.err != nil {
+		return response, 6, redilocation, dest.err
+	} else if err != nil {
+		return response, doErrCode(err), redilocation, err
+	}
+	if cerr := sums.mismatch(httpurl, false); cerr != nil {
+		return response, 8, redilocation, cerr
+	}
+	if headersums != nil {
+		if cerr := headersums.mismatch(httpurl, true); cerr != nil {
+			return response, 8, redilocation, cerr
+		}
+	}
+	return response, response.StatusCode, redilocation, nil
+}
+
+//fetchToFile fetches httpurl into a temporary file next to dest and renames it to dest only when
+//fetch succeeded, dest is never left half written.
+func (c *Client) fetchToFile(httpurl string, httpsendhead []string, cookie []*http.Cookie, dest string, checksums []*Checksum, fromheader bool, contimeout, datatrantimeout time.Duration) (response *http.Response, httpretcode int, redilocation string, err error) {
+	var tmp *os.File
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	response, httpretcode, redilocation, err = c.fetch(httpurl, httpsendhead, cookie, checksums, fromheader, contimeout, datatrantimeout, func(*http.Response) (io.Writer, error) {
+		var err error
+		tmp, err = ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest)+".part")
+		return tmp, err
+	})
+	if err != nil || httpretcode < 200 || httpretcode >= 300 {
+		return response, httpretcode, redilocation, err
+	}
+	if err = tmp.Close(); err != nil {
+		return response, 6, redilocation, err
+	}
+	os.Chmod(tmp.Name(), 0644)
+	if err = os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+		tmp = nil
+		return response, 6, redilocation, err
+	}
+	tmp = nil
+	return response, httpretcode, redilocation, nil
+}
+
+//parseChecksums parses the comma separated checksums of the *Checksum functions.
+func parseChecksums(checksum string) ([]*Checksum, error) {
+	var checksums []*Checksum
+	for _, s := range strings.Split(checksum, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		sum, err := ParseChecksum(s)
+		if err != nil {
+			return nil, err
+		}
+		checksums = append(checksums, sum)
+	}
+	return checksums, nil
+}
+
+//UrlGetToFileChecksum downloads httpurl to filepath verifying checksum, comma separated digests like
+//"sha256:<hex>" (see ParseChecksum), and the digests of the Content-MD5, Digest and Repr-Digest response
+//headers. The content is hashed while it is written to a temporary file, which becomes filepath only
+//when every digest matches. httpretcode is the status code on success, 8 with a *ChecksumError on
+//a mismatch, 3 for bad httpgetdata or checksum, 5 uncompress error, 6 file error.
+func (c *Client) UrlGetToFileChecksum(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, filepath string, checksum string, contimeout, datatrantimeout time.Duration) (head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	httpurl, ok := appendQuery(httpurl, httpgetdata)
+	checksums, err := parseChecksums(checksum)
+	if !ok || err != nil {
+		return http.Header{}, nil, 3, redilocation, err
+	}
+	response, code, redilocation, err := c.fetchToFile(httpurl, httpsendhead, cookie, filepath, checksums, true, contimeout, datatrantimeout)
+	if response == nil {
+		return http.Header{}, nil, code, redilocation, err
+	}
+	return response.Header, response.Cookies(), code, redilocation, err
+}
+
+//UrlGetChecksum is UrlGet verifying the content like UrlGetToFileChecksum, content is empty unless it matches.
+func (c *Client) UrlGetChecksum(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, checksum string, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	httpurl, ok := appendQuery(httpurl, httpgetdata)
+	checksums, err := parseChecksums(checksum)
+	if !ok || err != nil {
+		return []byte(""), http.Header{}, nil, 3, redilocation, err
+	}
+	var buf bytes.Buffer
+	response, code, redilocation, err := c.fetch(httpurl, httpsendhead, cookie, checksums, true, contimeout, datatrantimeout, func(*http.Response) (io.Writer, error) {
+		return &buf, nil
+	})
+	if response == nil {
+		return []byte(""), http.Header{}, nil, code, redilocation, err
+	}
+	if err != nil || code < 200 || code >= 300 {
+		return []byte(""), response.Header, response.Cookies(), code, redilocation, err
+	}
+	return buf.Bytes(), response.Header, response.Cookies(), code, redilocation, nil
+}
+
+//filePiece is a byte range of a segmented download with its expected digest, if any.
+type filePiece struct {
+	start, end int64 //end is inclusive like a Range header
+	sum        *Checksum
+}
+
+//offsetWriter writes sequentially into w from an offset.
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+//fetchPiece GETs the range of p from httpurl into w with client, shared by the pieces of a download,
+//verifying its hash. ifrange, when not empty, is sent as If-Range so a changed resource fails instead
+//of mixing versions.
+func (c *Client) fetchPiece(client *httpClient, httpurl string, w io.WriterAt, p filePiece, ifrange string, httpsendhead []string, cookie []*http.Cookie) (int, error) {
+	request, err := http.NewRequest("GET", httpurl, nil)
+	if err != nil {
+		return 1, err
+	}
+	setRequestHead(request, "", httpsendhead, cookie)
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", p.start, p.end))
+	//offsets are of the unencoded content
+	request.Header.Set("Accept-Encoding", "identity")
+	if ifrange != "" {
+		request.Header.Set("If-Range", ifrange)
+	}
+	response, err := c.do(client, request)
+	if err != nil {
+		return doErrCode(err), err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusPartialContent {
+		code := response.StatusCode
+		if code >= 200 && code < 300 {
+			//the range was ignored, or the resource changed with If-Range
+			code = 6
+		}
+		return code, fmt.Errorf("%s: range request answered with status %d", httpurl, response.StatusCode)
+	}
+	if !strings.HasPrefix(response.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(p.start, 10)+"-") {
+		return 6, fmt.Errorf("%s: bad Content-Range %q", httpurl, response.Header.Get("Content-Range"))
+	}
+	length := p.end - p.start + 1
+	sums := newChecksumWriter(nil)
+	if p.sum != nil {
+		sums = newChecksumWriter([]*Checksum{p.sum})
+	}
+	//a corrupt piece is overwritten by the next mirror or found by verifyPiece when resuming
+	dest := &errWriter{w: &offsetWriter{w, p.start}}
+	n, err := io.Copy(io.MultiWriter(dest, sums), io.LimitReader(response.Body, length))
+	if dest.err != nil {
+		return 6, dest.err
+	} else if err != nil {
+		return doErrCode(err), err
+	}
+	if n != length {
+		return 2, fmt.Errorf("%s: piece at %d truncated to %d bytes", httpurl, p.start, n)
+	}
+	//a body closed before its end closes the connection instead of keeping it for the next piece
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 4096))
+	if cerr := sums.mismatch(httpurl, false); cerr != nil {
+		return 8, cerr
+	}
+	return response.StatusCode, nil
+}
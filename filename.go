@@ -0,0 +1,215 @@
+// netutil project filename.go
+package netutil
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//collision modes of UrlGetToDir when the file exists
+const (
+	FileOverwrite = iota
+	FileSkip
+	//FileRename saves as "name (1).ext", "name (2).ext" and so on.
+	FileRename
+)
+
+//ErrFileExists is returned by UrlGetToDir with FileSkip, httpretcode is 9 then.
+var ErrFileExists = errors.New("file exists")
+
+//ContentDispositionFileName returns the file name of a Content-Disposition header (RFC 6266),
+//filename* (RFC 8187, UTF-8 or ISO-8859-1) is preferred to filename. The name is not sanitized.
+func ContentDispositionFileName(disposition string) string {
+	//mime only decodes UTF-8 filename* and rejects common malformed headers, e.g. unquoted spaces
+	var plain, extended string
+	for _, param := range strings.Split(disposition, ";") {
+		i := strings.Index(param, "=")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(param[:i]))
+		value := strings.TrimSpace(param[i+1:])
+		switch key {
+		case "filename":
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			plain = strings.Trim(value, `"`)
+		case "filename*":
+			parts := strings.SplitN(value, "'", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			decoded, err := url.PathUnescape(parts[2])
+			if err != nil {
+				continue
+			}
+			if strings.EqualFold(parts[0], "iso-8859-1") {
+				runes := make([]rune, len(decoded))
+				for i := 0; i < len(decoded); i++ {
+					runes[i] = rune(decoded[i])
+				}
+				decoded = string(runes)
+			}
+			if utf8.ValidString(decoded) {
+				extended = decoded
+			}
+		}
+	}
+	if extended != "" {
+		return extended
+	}
+	if _, params, err := mime.ParseMediaType(disposition); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	return plain
+}
+
+//SanitizeFileName makes name safe as a single file name on Unix and Windows: directories are dropped,
+//control and reserved characters replaced by "_", trailing dots and spaces removed, reserved device
+//names prefixed and the length limited to 255 bytes keeping the extension. "" is returned when nothing remains.
+func SanitizeFileName(name string) string {
+	name = strings.Replace(name, "\\", "/", -1)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(strings.TrimRight(name, ". "), " ")
+	if name == "" || strings.Trim(name, ".") == "" {
+		return ""
+	}
+	base := strings.ToUpper(strings.SplitN(name, ".", 2)[0])
+	switch base {
+	case "CON", "PRN", "AUX", "NUL",
+		"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+		"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9":
+		name = "_" + name
+	}
+	if len(name) > 255 {
+		ext := path.Ext(name)
+		if len(ext) > 32 {
+			ext = ""
+		}
+		stem := name[:255-len(ext)]
+		for !utf8.ValidString(stem) {
+			stem = stem[:len(stem)-1]
+		}
+		name = stem + ext
+	}
+	return name
+}
+
+//ResponseFileName returns the sanitized file name of a response: the Content-Disposition file name,
+//else the last path segment of the final url after redirects, else "download".
+func ResponseFileName(response *http.Response) string {
+	if name := SanitizeFileName(ContentDispositionFileName(response.Header.Get("Content-Disposition"))); name != "" {
+		return name
+	}
+	if response.Request != nil && response.Request.URL != nil {
+		segment := path.Base(response.Request.URL.Path)
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		if name := SanitizeFileName(segment); name != "" {
+			return name
+		}
+	}
+	return "download"
+}
+
+//renameNoClobber renames tmp to dest, or to "dest (n).ext" with the first n free.
+func renameNoClobber(tmp, dest string) (string, error) {
+	ext := filepath.Ext(dest)
+	stem := strings.TrimSuffix(dest, ext)
+	if strings.EqualFold(filepath.Ext(stem), ".tar") {
+		ext = stem[len(stem)-4:] + ext
+		stem = stem[:len(stem)-4]
+	}
+	candidate := dest
+	for n := 1; ; n++ {
+		//claim the name, so a concurrent download cannot take it before the rename
+		f, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			if err = os.Rename(tmp, candidate); err != nil {
+				os.Remove(candidate)
+				return "", err
+			}
+			return candidate, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		candidate = stem + " (" + strconv.Itoa(n) + ")" + ext
+	}
+}
+
+//UrlGetToDir downloads httpurl into dir, naming the file by ResponseFileName. An existing file is
+//replaced, kept or the new one renamed according to collision (FileOverwrite, FileSkip, FileRename).
+//The content goes to a temporary file first, so no file is left half written. savedpath is the file
+//written, or the existing one with FileSkip, httpretcode 9 and ErrFileExists. httpretcode is otherwise
+//the status code, 3 for bad httpgetdata, 5 uncompress error, 6 file error.
+func (c *Client) UrlGetToDir(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, dir string, collision int, contimeout, datatrantimeout time.Duration) (savedpath string, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	httpurl, ok := appendQuery(httpurl, httpgetdata)
+	if !ok {
+		return "", http.Header{}, nil, 3, redilocation, nil
+	}
+	var tmp *os.File
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	response, code, redilocation, err := c.fetch(httpurl, httpsendhead, cookie, nil, false, contimeout, datatrantimeout, func(response *http.Response) (io.Writer, error) {
+		savedpath = filepath.Join(dir, ResponseFileName(response))
+		if collision == FileSkip {
+			if _, err := os.Stat(savedpath); err == nil {
+				return nil, ErrFileExists
+			}
+		}
+		var err error
+		tmp, err = ioutil.TempFile(dir, ".download")
+		return tmp, err
+	})
+	if response == nil {
+		return "", http.Header{}, nil, code, redilocation, err
+	}
+	head, retcookie = response.Header, response.Cookies()
+	if err != nil || code < 200 || code >= 300 {
+		if err != ErrFileExists {
+			savedpath = ""
+		}
+		return savedpath, head, retcookie, code, redilocation, err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", head, retcookie, 6, redilocation, err
+	}
+	os.Chmod(tmp.Name(), 0644)
+	if collision == FileRename {
+		savedpath, err = renameNoClobber(tmp.Name(), savedpath)
+	} else {
+		err = os.Rename(tmp.Name(), savedpath)
+	}
+	if err != nil {
+		return "", head, retcookie, 6, redilocation, err
+	}
+	tmp = nil
+	return savedpath, head, retcookie, code, redilocation, nil
+}
@@ -0,0 +1,110 @@
+// netutil project filename_test.go
+package netutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentDispositionFileName(t *testing.T) {
+	for _, tc := range []struct{ disposition, want string }{
+		{`attachment; filename="report.pdf"`, "report.pdf"},
+		{`attachment; filename="fallback.txt"; filename*=UTF-8''%e2%82%ac%20rates.txt`, "€ rates.txt"},
+		{`attachment; filename*=iso-8859-1''%E4rger.txt`, "ärger.txt"},
+		{`attachment; filename=my file.txt`, "my file.txt"},
+		{`attachment; filename="../../etc/passwd"`, "../../etc/passwd"},
+		{`inline`, ""},
+		{``, ""},
+	} {
+		if got := ContentDispositionFileName(tc.disposition); got != tc.want {
+			t.Errorf("%s: %q, want %q", tc.disposition, got, tc.want)
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	for _, tc := range []struct{ name, want string }{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Windows\evil.exe`, "evil.exe"},
+		{"a<b>:c?.txt. ", "a_b__c_.txt"},
+		{"tab\there", "tab_here"},
+		{"CON.txt", "_CON.txt"},
+		{"lpt1", "_lpt1"},
+		{"console.txt", "console.txt"},
+		{"..", ""},
+		{"dir/", ""},
+	} {
+		if got := SanitizeFileName(tc.name); got != tc.want {
+			t.Errorf("%q: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	long := SanitizeFileName(strings.Repeat("é", 200) + ".tar.gz")
+	if len(long) > 255 || !strings.HasSuffix(long, "é.gz") {
+		t.Errorf("long name of %d bytes: ...%q", len(long), long[len(long)-10:])
+	}
+}
+
+//newNamingServer gives /cd a Content-Disposition name and redirects /redir to a named path.
+func newNamingServer(t *testing.T) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cd":
+			w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''na%C3%AFve.bin`)
+		case "/redir":
+			http.Redirect(w, r, "/files/final%20name.tar.gz?x=1", http.StatusFound)
+			return
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	})
+}
+
+func TestUrlGetToDir(t *testing.T) {
+	srv := newNamingServer(t)
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		path      string
+		collision int
+		name      string
+		code      int
+	}{
+		{"/cd", FileOverwrite, "naïve.bin", 200},
+		{"/redir", FileOverwrite, "final name.tar.gz", 200},
+		{"/redir", FileOverwrite, "final name.tar.gz", 200},
+		{"/redir", FileRename, "final name (1).tar.gz", 200},
+		{"/redir", FileRename, "final name (2).tar.gz", 200},
+		{"/redir", FileSkip, "final name.tar.gz", 9},
+		{"/", FileOverwrite, "download", 200},
+	} {
+		savedpath, _, _, code, _, err := UrlGetToDir(srv.URL+tc.path, nil, nil, nil, dir, tc.collision, time.Second, 5*time.Second)
+		if savedpath != filepath.Join(dir, tc.name) || code != tc.code {
+			t.Errorf("%s collision %d: %q httpretcode %d %v", tc.path, tc.collision, savedpath, code, err)
+		}
+		if code == 9 && err != ErrFileExists {
+			t.Errorf("FileSkip: error %v", err)
+		}
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "final name (2).tar.gz")); string(b) != "/files/final name.tar.gz" {
+		t.Errorf("content %q", b)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 5 {
+		t.Errorf("%d files, want 5", len(files))
+	}
+
+	//a failed download leaves nothing
+	savedpath, _, _, code, _, _ := UrlGetToDir(srv.URL+"/missing", nil, nil, nil, dir, FileOverwrite, time.Second, 5*time.Second)
+	if code != 404 || savedpath != "" {
+		t.Errorf("404: %q httpretcode %d", savedpath, code)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 5 {
+		t.Errorf("a 404 left %d files", len(files))
+	}
+}
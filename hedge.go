@@ -0,0 +1,189 @@
+// netutil project hedge.go
+package netutil
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//HedgePolicy sends up to MaxHedges copies of an idempotent request when it has no response after
+//a delay, the first response wins and the other requests are canceled. Only GET, HEAD, OPTIONS,
+//TRACE and bodyless PUT and DELETE requests are hedged. Share one policy between the Clients of
+//a backend so the observed latencies are shared too.
+type HedgePolicy struct {
+	//Delay before sending a hedged request, 0 means 100ms. It is used until MinSamples latencies
+	//were observed when Percentile is set.
+	Delay time.Duration
+	//Percentile, e.g. 0.95, derives the delay from the observed response header latencies, 0 uses Delay only.
+	Percentile float64
+	//MinSamples is the number of latencies needed before Percentile is used, 0 means 20.
+	MinSamples int
+	//MaxHedges is the number of extra requests, 0 means 1.
+	MaxHedges int
+
+	mu        sync.Mutex
+	samples   []time.Duration //ring of the last latencies
+	next      int
+	requests  int64
+	hedged    int64
+	hedgewins int64
+}
+
+const hedgeSamples = 256
+
+func NewHedgePolicy(delay time.Duration, percentile float64) *HedgePolicy {
+	return &HedgePolicy{Delay: delay, Percentile: percentile}
+}
+
+//delay returns the time to wait before the next hedged request.
+func (p *HedgePolicy) delay() time.Duration {
+	delay := p.Delay
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+	if p.Percentile <= 0 {
+		return delay
+	}
+	minsamples := p.MinSamples
+	if minsamples <= 0 {
+		minsamples = 20
+	}
+	p.mu.Lock()
+	if len(p.samples) < minsamples {
+		p.mu.Unlock()
+		return delay
+	}
+	sorted := append([]time.Duration(nil), p.samples...)
+	p.mu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(p.Percentile*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (p *HedgePolicy) observe(latency time.Duration, hedged, hedgewin bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.samples) < hedgeSamples {
+		p.samples = append(p.samples, latency)
+	} else {
+		p.samples[p.next] = latency
+		p.next = (p.next + 1) % hedgeSamples
+	}
+	p.requests++
+	if hedged {
+		p.hedged++
+	}
+	if hedgewin {
+		p.hedgewins++
+	}
+}
+
+//Stats returns the number of hedgeable requests answered, how many of them sent hedged requests
+//and how many were answered by a hedged request.
+func (p *HedgePolicy) Stats() (requests, hedged, hedgewins int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests, p.hedged, p.hedgewins
+}
+
+func hedgeable(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
+
+//hedgeTransport sends the hedged copies of a request, each copy has its own context
+//canceled when it lost or, for the winner, when its body is closed.
+type hedgeTransport struct {
+	policy *HedgePolicy
+	next   http.RoundTripper
+}
+
+type hedgeResult struct {
+	resp *http.Response
+	err  error
+	i    int
+}
+
+func (t *hedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !hedgeable(req) {
+		return t.next.RoundTrip(req)
+	}
+	maxhedges := t.policy.MaxHedges
+	if maxhedges <= 0 {
+		maxhedges = 1
+	}
+	results := make(chan hedgeResult, maxhedges+1)
+	var cancels []context.CancelFunc
+	var proxies []*requestProxy
+	var starts []time.Time
+	send := func() {
+		ctx, cancel := context.WithCancel(req.Context())
+		//each copy records its own proxy, the winner's is handed to the caller
+		ctx, proxy := withRequestProxy(ctx)
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		proxies = append(proxies, proxy)
+		starts = append(starts, time.Now())
+		go func() {
+			resp, err := t.next.RoundTrip(req.Clone(ctx))
+			results <- hedgeResult{resp, err, i}
+		}()
+	}
+
+	send()
+	pending := 1
+	delay := t.policy.delay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var lasterr error
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) <= maxhedges {
+				send()
+				pending++
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				cancels[r.i]()
+				lasterr = r.err
+				if pending > 0 {
+					continue
+				}
+				//every request sent failed, the caller gets the proxy of the last one
+				requestProxyFrom(req.Context()).copy(proxies[r.i])
+				return nil, lasterr
+			}
+			for i, cancel := range cancels {
+				if i != r.i {
+					cancel()
+				}
+			}
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if lost := <-results; lost.resp != nil {
+						lost.resp.Body.Close()
+					}
+				}
+			}(pending)
+			t.policy.observe(time.Since(starts[r.i]), len(cancels) > 1, r.i > 0)
+			requestProxyFrom(req.Context()).copy(proxies[r.i])
+			r.resp.Body = &releaseBody{r.resp.Body, cancels[r.i]}
+			return r.resp, nil
+		}
+	}
+}
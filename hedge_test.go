@@ -0,0 +1,134 @@
+// netutil project hedge_test.go
+package netutil
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newHedgeServer answers "reqN" to the Nth request, the first slow requests wait 2s
+//unless they are canceled, which is counted.
+func newHedgeServer(t *testing.T, slow int32) (srv *testServer, hits, canceled *int32) {
+	hits, canceled = new(int32), new(int32)
+	srv = newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		i := atomic.AddInt32(hits, 1)
+		if i <= slow {
+			select {
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+				atomic.AddInt32(canceled, 1)
+				return
+			}
+		}
+		fmt.Fprintf(w, "req%d", i)
+	})
+	return srv, hits, canceled
+}
+
+func TestHedgeWins(t *testing.T) {
+	srv, hits, canceled := newHedgeServer(t, 1)
+	p := NewHedgePolicy(50*time.Millisecond, 0)
+	c := &Client{Hedge: p}
+	start := time.Now()
+	content, _, _, code, _ := c.UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "req2" || time.Since(start) > time.Second {
+		t.Fatalf("httpretcode %d %q after %v", code, content, time.Since(start))
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(canceled) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(canceled) != 1 || atomic.LoadInt32(hits) != 2 {
+		t.Errorf("%d requests, %d canceled, want the slow one canceled", atomic.LoadInt32(hits), atomic.LoadInt32(canceled))
+	}
+	if requests, hedged, hedgewins := p.Stats(); requests != 1 || hedged != 1 || hedgewins != 1 {
+		t.Errorf("Stats %d %d %d", requests, hedged, hedgewins)
+	}
+
+	//a fast response sends no copy
+	content, _, _, code, _ = c.UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "req3" || atomic.LoadInt32(hits) != 3 {
+		t.Errorf("fast response: httpretcode %d %q, %d requests", code, content, atomic.LoadInt32(hits))
+	}
+	if requests, hedged, _ := p.Stats(); requests != 2 || hedged != 1 {
+		t.Errorf("Stats %d %d after a fast response", requests, hedged)
+	}
+}
+
+func TestHedgeMaxHedges(t *testing.T) {
+	srv, hits, _ := newHedgeServer(t, 2)
+	p := &HedgePolicy{Delay: 30 * time.Millisecond, MaxHedges: 2}
+	content, _, _, code, _ := (&Client{Hedge: p}).UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "req3" || atomic.LoadInt32(hits) != 3 {
+		t.Errorf("httpretcode %d %q, %d requests", code, content, atomic.LoadInt32(hits))
+	}
+}
+
+func TestHedgeNotIdempotent(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+	})
+	p := NewHedgePolicy(20*time.Millisecond, 0)
+	if _, _, _, code, _ := (&Client{Hedge: p}).UrlPost(srv.URL, []string{"a", "b"}, false, nil, nil, time.Second, 5*time.Second); code != 200 {
+		t.Fatalf("httpretcode %d", code)
+	}
+	if srv.requests() != 1 {
+		t.Errorf("a POST was sent %d times", srv.requests())
+	}
+	if requests, _, _ := p.Stats(); requests != 0 {
+		t.Errorf("a POST was counted as hedgeable")
+	}
+}
+
+//TestHedgeAllFailed checks a failed request is not sent again when no other copy is pending, and the
+//proxy of the failed copy is reported.
+func TestHedgeAllFailed(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	})
+	_, _, _, code, _ := (&Client{Hedge: NewHedgePolicy(time.Second, 0)}).UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 2 || srv.requests() != 1 {
+		t.Errorf("httpretcode %d, %d requests", code, srv.requests())
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := ln.Addr().String()
+	ln.Close()
+	pool, err := NewProxyPool(ProxyRoundRobin, "http://"+dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	c := &Client{ProxyPool: pool, Hedge: NewHedgePolicy(time.Second, 0)}
+	if _, _, _, code, _ := c.UrlGet("http://hedge.test/", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 2 {
+		t.Errorf("dead proxy: httpretcode %d", code)
+	}
+	if s := poolStat(pool, dead); s.Failed != 1 {
+		t.Errorf("the failure of the proxy was not reported: %+v", s)
+	}
+}
+
+func TestHedgePercentile(t *testing.T) {
+	p := &HedgePolicy{Delay: time.Second, Percentile: 0.9, MinSamples: 10}
+	for i := 1; i <= 9; i++ {
+		p.observe(time.Duration(i)*time.Millisecond, false, false)
+	}
+	if d := p.delay(); d != time.Second {
+		t.Errorf("delay %v before MinSamples, want Delay", d)
+	}
+	p.observe(10*time.Millisecond, false, false)
+	if d := p.delay(); d != 9*time.Millisecond {
+		t.Errorf("delay %v, want the 90th percentile 9ms", d)
+	}
+	if d := (&HedgePolicy{}).delay(); d != 100*time.Millisecond {
+		t.Errorf("default delay %v", d)
+	}
+}
@@ -0,0 +1,175 @@
+// netutil project hostlimit.go
+package netutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//HostLimiter keeps requests polite per host: at most RequestsPerSecond with bursts of Burst,
+//at most MaxConcurrent requests in flight and at least MinDelay between two request starts.
+//A zero field is no limit. Requests wait in turn and give up when their context is done.
+type HostLimiter struct {
+	RequestsPerSecond float64
+	Burst             int
+	MaxConcurrent     int
+	MinDelay          time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	sem       chan struct{}
+	tat       time.Time //theoretical arrival time of the rate limit (GCRA)
+	laststart time.Time
+}
+
+func NewHostLimiter(rps float64, burst, maxconcurrent int, mindelay time.Duration) *HostLimiter {
+	return &HostLimiter{RequestsPerSecond: rps, Burst: burst, MaxConcurrent: maxconcurrent, MinDelay: mindelay}
+}
+
+func (h *HostLimiter) state(host string) *hostState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hosts == nil {
+		h.hosts = map[string]*hostState{}
+	}
+	s, ok := h.hosts[host]
+	if !ok {
+		s = &hostState{}
+		if h.MaxConcurrent > 0 {
+			s.sem = make(chan struct{}, h.MaxConcurrent)
+		}
+		h.hosts[host] = s
+	}
+	return s
+}
+
+//schedule reserves the start time of the next request to s, it returns the previous start too
+//for unschedule.
+func (h *HostLimiter) schedule(s *hostState) (start, laststart time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	start = time.Now()
+	if h.RequestsPerSecond > 0 {
+		interval := time.Duration(float64(time.Second) / h.RequestsPerSecond)
+		burst := h.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		if t := s.tat.Add(-time.Duration(burst-1) * interval); t.After(start) {
+			start = t
+		}
+		if s.tat.Before(start) {
+			s.tat = start
+		}
+		s.tat = s.tat.Add(interval)
+	}
+	if h.MinDelay > 0 && !s.laststart.IsZero() {
+		if t := s.laststart.Add(h.MinDelay); t.After(start) {
+			start = t
+		}
+	}
+	laststart, s.laststart = s.laststart, start
+	return start, laststart
+}
+
+//unschedule gives back the start reserved by schedule for a request which gave up, the
+//MinDelay counts from laststart again unless another request was scheduled since.
+func (h *HostLimiter) unschedule(s *hostState, start, laststart time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.RequestsPerSecond > 0 {
+		s.tat = s.tat.Add(-time.Duration(float64(time.Second) / h.RequestsPerSecond))
+	}
+	if s.laststart.Equal(start) {
+		s.laststart = laststart
+	}
+}
+
+//Acquire waits until a request to host may start, release must be called when it is finished.
+func (h *HostLimiter) Acquire(ctx context.Context, host string) (release func(), err error) {
+	s := h.state(strings.ToLower(host))
+	if s.sem != nil {
+		select {
+		case s.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			if s.sem != nil {
+				<-s.sem
+			}
+		})
+	}
+	start, laststart := h.schedule(s)
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			h.unschedule(s, start, laststart)
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+//hostLimitTransport acquires the HostLimiter for every request, redirects included,
+//and releases it when the response body is closed.
+type hostLimitTransport struct {
+	limiter *HostLimiter
+	next    http.RoundTripper
+}
+
+func (t *hostLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.Acquire(req.Context(), req.URL.Hostname())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, &hostWaitError{err}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{resp.Body, release}
+	return resp, nil
+}
+
+//hostWaitError is the error of a request which gave up waiting for the HostLimiter,
+//it unwraps to the context error.
+type hostWaitError struct {
+	err error
+}
+
+func (e *hostWaitError) Error() string {
+	return "waiting for host limit: " + e.err.Error()
+}
+
+func (e *hostWaitError) Unwrap() error {
+	return e.err
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,143 @@
+// netutil project hostlimit_test.go
+package netutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//acquireTimes acquires h n times for host and returns when each acquire returned, from the first.
+func acquireTimes(t *testing.T, h *HostLimiter, host string, n int) []time.Duration {
+	var times []time.Duration
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		release, err := h.Acquire(context.Background(), host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, time.Since(start))
+		release()
+	}
+	return times
+}
+
+func TestHostLimiterRate(t *testing.T) {
+	//5 per second: 200ms apart
+	times := acquireTimes(t, NewHostLimiter(5, 1, 0, 0), "a.test", 3)
+	if times[1] < 180*time.Millisecond || times[2] < 380*time.Millisecond {
+		t.Errorf("rate 5/s: acquired at %v", times)
+	}
+	//a burst of 3 passes at once, the 4th waits
+	times = acquireTimes(t, NewHostLimiter(5, 3, 0, 0), "a.test", 4)
+	if times[2] > 50*time.Millisecond || times[3] < 150*time.Millisecond {
+		t.Errorf("burst 3: acquired at %v", times)
+	}
+	times = acquireTimes(t, NewHostLimiter(0, 0, 0, 150*time.Millisecond), "a.test", 2)
+	if times[1] < 130*time.Millisecond {
+		t.Errorf("MinDelay: acquired at %v", times)
+	}
+}
+
+func TestHostLimiterPerHost(t *testing.T) {
+	h := NewHostLimiter(1, 1, 0, 0)
+	acquireTimes(t, h, "a.test", 1)
+	//another host has its own limit, a host name is case insensitive
+	if times := acquireTimes(t, h, "b.test", 1); times[0] > 50*time.Millisecond {
+		t.Errorf("another host waited %v", times[0])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := h.Acquire(ctx, "A.TEST"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("same host in upper case: %v", err)
+	}
+}
+
+//TestHostLimiterCancel checks a request giving up its wait does not delay the next ones.
+func TestHostLimiterCancel(t *testing.T) {
+	for _, h := range []*HostLimiter{NewHostLimiter(1, 1, 0, 0), NewHostLimiter(0, 0, 0, time.Second)} {
+		acquireTimes(t, h, "a.test", 1)
+		for i := 0; i < 3; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			if _, err := h.Acquire(ctx, "a.test"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("canceled wait: %v", err)
+			}
+			cancel()
+		}
+		//the next start is one interval after the first request, not after the canceled ones
+		if start, _ := h.schedule(h.state("a.test")); time.Until(start) > time.Second {
+			t.Errorf("rate %v MinDelay %v: next start in %v after 3 canceled waits", h.RequestsPerSecond, h.MinDelay, time.Until(start))
+		}
+	}
+}
+
+func TestHostLimiterConcurrent(t *testing.T) {
+	var inflight, maxinflight int32
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			max := atomic.LoadInt32(&maxinflight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxinflight, max, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+	})
+	c := &Client{HostLimit: NewHostLimiter(0, 0, 2, 0)}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, _, code, _ := c.UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 200 {
+				t.Errorf("httpretcode %d", code)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxinflight != 2 {
+		t.Errorf("%d requests in flight, want 2", maxinflight)
+	}
+}
+
+//TestHostLimiterContext checks a request gives up waiting when its context is done.
+func TestHostLimiterContext(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	c := &Client{HostLimit: NewHostLimiter(1, 1, 0, 0)}
+	c.UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var err error
+	wc := c.WithContext(ctx)
+	wc.OnResponse = func(meta *ResponseMeta) { err = meta.Err }
+	start := time.Now()
+	_, _, _, code, _ := wc.UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 2 || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("httpretcode %d after %v", code, time.Since(start))
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want the context error", err)
+	}
+
+	//a cancelled wait for a concurrency slot gives the slot back
+	h := NewHostLimiter(0, 0, 1, 0)
+	release, _ := h.Acquire(context.Background(), "a.test")
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := h.Acquire(ctx, "a.test"); err == nil {
+		t.Fatal("acquired a busy slot")
+	}
+	release()
+	release()
+	if release, err := h.Acquire(context.Background(), "a.test"); err != nil {
+		t.Error(err)
+	} else {
+		release()
+	}
+}
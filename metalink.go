@@ -0,0 +1,338 @@
+// netutil project metalink.go
+package netutil
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Metalink is a Metalink 4 document (RFC 5854, .meta4).
+type Metalink struct {
+	Files []*MetalinkFile `xml:"file"`
+}
+
+type MetalinkFile struct {
+	//Name is a relative path, it may contain directories.
+	Name string `xml:"name,attr"`
+	//Size is 0 when unknown, the file is then downloaded in one piece.
+	Size   int64           `xml:"size"`
+	Hashes []MetalinkHash  `xml:"hash"`
+	Pieces *MetalinkPieces `xml:"pieces"`
+	URLs   []MetalinkURL   `xml:"url"`
+}
+
+//MetalinkHash is a hex digest, Type is the IANA name like "sha-256".
+type MetalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+//MetalinkPieces are the digests of consecutive pieces of Length bytes, the last one may be shorter.
+type MetalinkPieces struct {
+	Length int64    `xml:"length,attr"`
+	Type   string   `xml:"type,attr"`
+	Hashes []string `xml:"hash"`
+}
+
+//MetalinkURL is a mirror, Priority 1 is the highest and 0 means none, Location is an ISO 3166-1 country code.
+type MetalinkURL struct {
+	Location string `xml:"location,attr"`
+	Priority int    `xml:"priority,attr"`
+	URL      string `xml:",chardata"`
+}
+
+func ParseMetalink(data []byte) (*Metalink, error) {
+	m := &Metalink{}
+	if err := xml.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if len(m.Files) == 0 {
+		return nil, errors.New("metalink without file")
+	}
+	return m, nil
+}
+
+func metalinkChecksum(typ, value string) *Checksum {
+	algorithm := strings.Replace(strings.ToLower(typ), "-", "", 1)
+	h := newHash(algorithm)
+	if h == nil {
+		return nil
+	}
+	sum, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(sum) != h.Size() {
+		return nil
+	}
+	return &Checksum{Algorithm: algorithm, Sum: sum}
+}
+
+//Checksum returns the strongest supported hash of the whole file, nil if none.
+func (f *MetalinkFile) Checksum() *Checksum {
+	for _, algorithm := range []string{"sha512", "sha256", "sha1", "md5"} {
+		for _, h := range f.Hashes {
+			if sum := metalinkChecksum(h.Type, h.Value); sum != nil && sum.Algorithm == algorithm {
+				return sum
+			}
+		}
+	}
+	return nil
+}
+
+//Mirrors returns the http and https urls of f, those in locations first, then by priority.
+func (f *MetalinkFile) Mirrors(locations []string) []string {
+	var urls []MetalinkURL
+	for _, u := range f.URLs {
+		u.URL = strings.TrimSpace(u.URL)
+		if strings.HasPrefix(u.URL, "http://") || strings.HasPrefix(u.URL, "https://") {
+			urls = append(urls, u)
+		}
+	}
+	local := func(u MetalinkURL) bool {
+		for _, l := range locations {
+			if strings.EqualFold(l, u.Location) {
+				return true
+			}
+		}
+		return false
+	}
+	priority := func(u MetalinkURL) int {
+		if u.Priority <= 0 {
+			return 1 << 30
+		}
+		return u.Priority
+	}
+	sort.SliceStable(urls, func(i, j int) bool {
+		if li, lj := local(urls[i]), local(urls[j]); li != lj {
+			return li
+		}
+		return priority(urls[i]) < priority(urls[j])
+	})
+	mirrors := make([]string, len(urls))
+	for i, u := range urls {
+		mirrors[i] = u.URL
+	}
+	return mirrors
+}
+
+//pieces splits f by its piece hashes or, without them, in ranges for connections.
+func (f *MetalinkFile) pieces(connections int) []filePiece {
+	length := f.Size / int64(connections)
+	if length < 1<<20 {
+		length = 1 << 20
+	}
+	var hashes []string
+	if f.Pieces != nil && f.Pieces.Length > 0 {
+		length = f.Pieces.Length
+		if int64(len(f.Pieces.Hashes)) == (f.Size+length-1)/length {
+			hashes = f.Pieces.Hashes
+		}
+	}
+	var pieces []filePiece
+	for i, start := 0, int64(0); start < f.Size; i, start = i+1, start+length {
+		p := filePiece{start: start, end: start + length - 1}
+		if p.end >= f.Size {
+			p.end = f.Size - 1
+		}
+		if hashes != nil {
+			p.sum = metalinkChecksum(f.Pieces.Type, hashes[i])
+		}
+		pieces = append(pieces, p)
+	}
+	return pieces
+}
+
+//verifyPiece checks a piece already in file.
+func verifyPiece(file *os.File, p filePiece) bool {
+	sums := newChecksumWriter([]*Checksum{p.sum})
+	if _, err := io.Copy(sums, io.NewSectionReader(file, p.start, p.end-p.start+1)); err != nil {
+		return false
+	}
+	return sums.mismatch("", false) == nil
+}
+
+//UrlGetMetalinkFile downloads f to filepath. The mirrors are ordered by Mirrors(locations) and
+//connections (0 means 4) pieces are fetched in parallel, spread over the best mirrors; a piece failing
+//on a mirror, including a piece hash mismatch, is fetched again from the next one. The pieces are
+//written to filepath+".part", which is kept on failure when f has piece hashes: calling again only
+//fetches the pieces missing or corrupt. Without piece hashes nothing can be verified before the end,
+//so a failure or a whole-file hash mismatch removes it and the next call downloads everything again.
+//filepath is written when the whole-file hash matches.
+//httpretcode is 200 on success, 3 without http mirror, 6 file error, 8 with a *ChecksumError when
+//the whole file does not match, else the code of the last failed attempt.
+func (c *Client) UrlGetMetalinkFile(f *MetalinkFile, filepath string, locations []string, connections int, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (httpretcode int, err error) {
+	mirrors := f.Mirrors(locations)
+	if len(mirrors) == 0 {
+		return 3, errors.New("metalink: no http mirror for " + f.Name)
+	}
+	if connections <= 0 {
+		connections = 4
+	}
+	var checksums []*Checksum
+	if whole := f.Checksum(); whole != nil {
+		checksums = append(checksums, whole)
+	}
+	if f.Size <= 0 {
+		for _, m := range mirrors {
+			_, httpretcode, _, err = c.fetchToFile(m, httpsendhead, cookie, filepath, checksums, true, contimeout, datatrantimeout)
+			if err == nil && httpretcode >= 200 && httpretcode < 300 {
+				return http.StatusOK, nil
+			}
+			if httpretcode == 6 {
+				break
+			}
+		}
+		return httpretcode, err
+	}
+
+	part := filepath + ".part"
+	_, staterr := os.Stat(part)
+	resume := staterr == nil
+	file, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 6, err
+	}
+	pieces := f.pieces(connections)
+	keep := len(pieces) > 0 && pieces[0].sum != nil
+	done := false
+	defer func() {
+		file.Close()
+		if !done && !keep {
+			os.Remove(part)
+		}
+	}()
+	if err = file.Truncate(f.Size); err != nil {
+		return 6, err
+	}
+
+	jobs := make(chan int, len(pieces))
+	for i, p := range pieces {
+		if resume && p.sum != nil && verifyPiece(file, p) {
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	spread := len(mirrors)
+	if spread > connections {
+		spread = connections
+	}
+	//the pieces share the connections to the mirrors
+	var redilocation string
+	client := c.newHttpClient(contimeout, datatrantimeout, &redilocation)
+	defer client.CloseIdleConnections()
+	var mu sync.Mutex
+	failures := make([]int, len(mirrors))
+	var wg sync.WaitGroup
+	for w := 0; w < connections; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				mu.Lock()
+				failed := err != nil
+				mu.Unlock()
+				if failed {
+					continue
+				}
+				code, perr := 0, error(nil)
+				for k := 0; k < len(mirrors); k++ {
+					m := (i + k) % spread
+					if k >= spread {
+						m = k
+					}
+					mu.Lock()
+					//a mirror failing 3 times is skipped while there is another
+					skip := failures[m] >= 3 && k < len(mirrors)-1
+					mu.Unlock()
+					if skip {
+						continue
+					}
+					code, perr = c.fetchPiece(client, mirrors[m], file, pieces[i], "", httpsendhead, cookie)
+					if perr == nil || code == 6 {
+						break
+					}
+					mu.Lock()
+					failures[m]++
+					mu.Unlock()
+				}
+				if perr != nil {
+					mu.Lock()
+					if err == nil {
+						httpretcode, err = code, perr
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err != nil {
+		return httpretcode, err
+	}
+
+	if len(checksums) > 0 {
+		sums := newChecksumWriter(checksums)
+		if _, err = io.Copy(sums, io.NewSectionReader(file, 0, f.Size)); err != nil {
+			return 6, err
+		}
+		if cerr := sums.mismatch(f.Name, false); cerr != nil {
+			//the pieces matched, so nothing can be re-fetched
+			keep = false
+			return 8, cerr
+		}
+	}
+	if err = file.Close(); err != nil {
+		return 6, err
+	}
+	if err = os.Rename(part, filepath); err != nil {
+		return 6, err
+	}
+	done = true
+	return http.StatusOK, nil
+}
+
+//metalinkPath joins dir and the name of a file of a metalink, refusing names leaving dir.
+func metalinkPath(dir, name string) (string, error) {
+	clean := path.Clean(strings.Replace(name, "\\", "/", -1))
+	if name == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || filepath.VolumeName(clean) != "" {
+		return "", errors.New("metalink: unsafe file name " + name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+//UrlGetMetalink fetches the Metalink document at metaurl and downloads all its files into dir with
+//UrlGetMetalinkFile, stopping at the first failure. paths are the files written. httpretcode is 200
+//on success, 6 for a bad document or unsafe file name, else the one of the failure.
+func (c *Client) UrlGetMetalink(metaurl string, dir string, locations []string, connections int, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (paths []string, httpretcode int, err error) {
+	data, _, _, code, _, err := c.UrlGetChecksum(metaurl, nil, httpsendhead, cookie, "", contimeout, datatrantimeout)
+	if err != nil || code < 200 || code >= 300 {
+		return nil, code, err
+	}
+	metalink, err := ParseMetalink(data)
+	if err != nil {
+		return nil, 6, err
+	}
+	for _, f := range metalink.Files {
+		dest, err := metalinkPath(dir, f.Name)
+		if err != nil {
+			return paths, 6, err
+		}
+		if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return paths, 6, err
+		}
+		if code, err = c.UrlGetMetalinkFile(f, dest, locations, connections, httpsendhead, cookie, contimeout, datatrantimeout); err != nil {
+			return paths, code, err
+		}
+		paths = append(paths, dest)
+	}
+	return paths, http.StatusOK, nil
+}
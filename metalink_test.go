@@ -0,0 +1,202 @@
+// netutil project metalink_test.go
+package netutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const metalinkPieceLength = 65536
+
+//metalinkData is 300000 random bytes, a piece of the copy served by corrupt has a flipped bit.
+type metalinkData struct {
+	data, corrupted []byte
+	pieces          []string
+}
+
+func newMetalinkData(t *testing.T) *metalinkData {
+	_, data := randomFile(t, 300000)
+	d := &metalinkData{data: data}
+	for s := 0; s < len(d.data); s += metalinkPieceLength {
+		e := s + metalinkPieceLength
+		if e > len(d.data) {
+			e = len(d.data)
+		}
+		d.pieces = append(d.pieces, fmt.Sprintf("<hash>%x</hash>", sha256.Sum256(d.data[s:e])))
+	}
+	d.corrupted = append([]byte(nil), d.data...)
+	d.corrupted[70000] ^= 1
+	return d
+}
+
+//document returns a metalink of the data named name, wholesum is the whole-file digest, urls the
+//url elements.
+func (d *metalinkData) document(name string, wholesum [32]byte, urls string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+ <file name="%s">
+  <size>%d</size>
+  <hash type="md5">00000000000000000000000000000000</hash>
+  <hash type="sha-256">%x</hash>
+  <pieces length="%d" type="sha-256">%s</pieces>
+  %s
+ </file>
+</metalink>`, name, len(d.data), wholesum, metalinkPieceLength, strings.Join(d.pieces, ""), urls)
+}
+
+//serveContent serves content with ranges.
+func serveContent(t *testing.T, content []byte) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	})
+}
+
+//newDocumentServer serves *doc.
+func newDocumentServer(t *testing.T, doc *string) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(*doc))
+	})
+}
+
+func TestParseMetalink(t *testing.T) {
+	d := newMetalinkData(t)
+	urls := `<url priority="2" location="us">http://us.test/f</url><url priority="1">http://one.test/f</url>` +
+		`<url location="de"> https://de.test/f </url><url priority="3">ftp://ftp.test/f</url>`
+	m, err := ParseMetalink([]byte(d.document("a/f.bin", sha256.Sum256(d.data), urls)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := m.Files[0]
+	if f.Name != "a/f.bin" || f.Size != 300000 || f.Pieces.Length != metalinkPieceLength || len(f.Pieces.Hashes) != 5 {
+		t.Errorf("parsed %+v", f)
+	}
+	if sum := f.Checksum(); sum == nil || sum.String() != fmt.Sprintf("sha256:%x", sha256.Sum256(d.data)) {
+		t.Errorf("Checksum %v, want the sha256", sum)
+	}
+	if got := fmt.Sprint(f.Mirrors([]string{"DE"})); got != "[https://de.test/f http://one.test/f http://us.test/f]" {
+		t.Errorf("Mirrors %s", got)
+	}
+	if got := fmt.Sprint(f.Mirrors(nil)); got != "[http://one.test/f http://us.test/f https://de.test/f]" {
+		t.Errorf("Mirrors without location %s", got)
+	}
+	if _, err := ParseMetalink([]byte(`<metalink xmlns="urn:ietf:params:xml:ns:metalink"></metalink>`)); err == nil {
+		t.Error("a metalink without file was accepted")
+	}
+}
+
+//TestUrlGetMetalink checks pieces failing on a mirror, by status or hash, are fetched from the next one.
+func TestUrlGetMetalink(t *testing.T) {
+	d := newMetalinkData(t)
+	good := serveContent(t, d.data)
+	bad := serveContent(t, d.corrupted)
+	notfound := newTestServer(t, http.NotFound)
+	doc := d.document("sub/data.bin", sha256.Sum256(d.data), fmt.Sprintf(`<url priority="1">%s</url><url priority="2">%s</url><url priority="3">%s</url>`, bad.URL, notfound.URL, good.URL))
+	ms := newDocumentServer(t, &doc)
+	dir := t.TempDir()
+
+	paths, code, err := UrlGetMetalink(ms.URL, dir, nil, 3, nil, nil, time.Second, 5*time.Second)
+	if code != 200 || err != nil || len(paths) != 1 || paths[0] != filepath.Join(dir, "sub", "data.bin") {
+		t.Fatalf("httpretcode %d %v paths %v", code, err, paths)
+	}
+	if b, _ := ioutil.ReadFile(paths[0]); !bytes.Equal(b, d.data) {
+		t.Error("downloaded content differs")
+	}
+	if good.requests() == 0 || bad.requests() == 0 {
+		t.Errorf("requests: %d to the good mirror, %d to the corrupt one", good.requests(), bad.requests())
+	}
+	if _, err := os.Stat(paths[0] + ".part"); !os.IsNotExist(err) {
+		t.Errorf("part file left: %v", err)
+	}
+}
+
+//TestUrlGetMetalinkResume checks a failed download keeps its verified pieces for the next call.
+func TestUrlGetMetalinkResume(t *testing.T) {
+	d := newMetalinkData(t)
+	good := serveContent(t, d.data)
+	bad := serveContent(t, d.corrupted)
+	doc := d.document("data.bin", sha256.Sum256(d.data), fmt.Sprintf(`<url>%s</url>`, bad.URL))
+	ms := newDocumentServer(t, &doc)
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "data.bin")
+
+	if _, code, err := UrlGetMetalink(ms.URL, dir, nil, 3, nil, nil, time.Second, 5*time.Second); code != 8 || err == nil {
+		t.Fatalf("corrupt mirror only: httpretcode %d %v", code, err)
+	}
+	if _, err := os.Stat(dest + ".part"); err != nil {
+		t.Fatalf("part file not kept: %v", err)
+	}
+	doc = d.document("data.bin", sha256.Sum256(d.data), fmt.Sprintf(`<url>%s</url>`, good.URL))
+	if _, code, err := UrlGetMetalink(ms.URL, dir, nil, 3, nil, nil, time.Second, 5*time.Second); code != 200 || err != nil {
+		t.Fatalf("resume: httpretcode %d %v", code, err)
+	}
+	if b, _ := ioutil.ReadFile(dest); !bytes.Equal(b, d.data) {
+		t.Error("resumed content differs")
+	}
+	//the pieces fetched before the failure are verified and kept, the corrupt one and those
+	//skipped after the failure are fetched
+	if kept := bad.requests() - 1; good.requests() != len(d.pieces)-kept {
+		t.Errorf("resume fetched %d pieces, %d were kept", good.requests(), kept)
+	}
+}
+
+//TestUrlGetMetalinkConnections checks the pieces share the connections to a mirror.
+func TestUrlGetMetalinkConnections(t *testing.T) {
+	d := newMetalinkData(t)
+	good := serveContent(t, d.data)
+	f := &MetalinkFile{Name: "f", Size: int64(len(d.data)), URLs: []MetalinkURL{{URL: good.URL}},
+		Pieces: &MetalinkPieces{Length: metalinkPieceLength, Type: "sha-256"}}
+	if code, err := (&Client{}).UrlGetMetalinkFile(f, filepath.Join(t.TempDir(), "f"), nil, 2, nil, nil, time.Second, 5*time.Second); code != 200 || err != nil {
+		t.Fatalf("httpretcode %d %v", code, err)
+	}
+	if good.requests() != len(d.pieces) || good.connections() > 2 {
+		t.Errorf("%d pieces fetched over %d connections, want at most 2", good.requests(), good.connections())
+	}
+	if !good.closed() {
+		t.Error("connections left open after the download")
+	}
+}
+
+func TestUrlGetMetalinkFailures(t *testing.T) {
+	d := newMetalinkData(t)
+	good := serveContent(t, d.data)
+	dir := t.TempDir()
+
+	//pieces matching a wrong whole-file hash cannot be fixed, nothing is kept
+	doc := d.document("data.bin", sha256.Sum256(nil), fmt.Sprintf(`<url>%s</url>`, good.URL))
+	ms := newDocumentServer(t, &doc)
+	_, code, err := UrlGetMetalink(ms.URL, dir, nil, 3, nil, nil, time.Second, 5*time.Second)
+	var cerr *ChecksumError
+	if code != 8 || !errors.As(err, &cerr) {
+		t.Errorf("whole-file mismatch: httpretcode %d %v", code, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("whole-file mismatch left %d files", len(files))
+	}
+
+	for _, name := range []string{"../evil", "/abs", "a/../../b", ""} {
+		doc = d.document(name, sha256.Sum256(d.data), fmt.Sprintf(`<url>%s</url>`, good.URL))
+		if _, code, err := UrlGetMetalink(ms.URL, dir, nil, 3, nil, nil, time.Second, 5*time.Second); code != 6 || err == nil {
+			t.Errorf("name %q: httpretcode %d %v", name, code, err)
+		}
+	}
+	f := &MetalinkFile{Name: "f", URLs: []MetalinkURL{{URL: "ftp://x/f"}}}
+	if code, err := (&Client{}).UrlGetMetalinkFile(f, filepath.Join(dir, "f"), nil, 0, nil, nil, time.Second, 5*time.Second); code != 3 || err == nil {
+		t.Errorf("no http mirror: httpretcode %d %v", code, err)
+	}
+
+	//without a size the file is fetched in one request
+	f = &MetalinkFile{Name: "f", URLs: []MetalinkURL{{URL: good.URL}}, Hashes: []MetalinkHash{{"sha-256", fmt.Sprintf("%x", sha256.Sum256(d.data))}}}
+	before := good.requests()
+	if code, err := (&Client{}).UrlGetMetalinkFile(f, filepath.Join(dir, "f"), nil, 0, nil, nil, time.Second, 5*time.Second); code != 200 || err != nil || good.requests()-before != 1 {
+		t.Errorf("unknown size: httpretcode %d %v, %d requests", code, err, good.requests()-before)
+	}
+}
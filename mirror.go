@@ -0,0 +1,106 @@
+// netutil project mirror.go
+package netutil
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//MirrorAttempt is one mirror tried by UrlGetToFileMirrors.
+type MirrorAttempt struct {
+	URL         string
+	HttpRetCode int
+	//Err explains a failure which is not a bad status code.
+	Err      error
+	Duration time.Duration
+}
+
+//probeMirrors orders mirrors by the latency of a HEAD request, mirrors failing the probe
+//go last in their original order.
+func (c *Client) probeMirrors(mirrors []string, httpsendhead []string, cookie []*http.Cookie, timeout time.Duration) []string {
+	latencies := make([]time.Duration, len(mirrors))
+	var redilocation string
+	client := c.newHttpClient(timeout, timeout, &redilocation)
+	defer client.CloseIdleConnections()
+	var wg sync.WaitGroup
+	for i, mirror := range mirrors {
+		wg.Add(1)
+		go func(i int, mirror string) {
+			defer wg.Done()
+			latencies[i] = -1
+			request, err := http.NewRequest("HEAD", mirror, nil)
+			if err != nil {
+				return
+			}
+			setRequestHead(request, "", httpsendhead, cookie)
+			start := time.Now()
+			response, err := c.do(client, request)
+			if err != nil {
+				return
+			}
+			response.Body.Close()
+			if response.StatusCode < 400 {
+				latencies[i] = time.Since(start)
+			}
+		}(i, mirror)
+	}
+	wg.Wait()
+	order := make([]int, len(mirrors))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		la, lb := latencies[order[a]], latencies[order[b]]
+		if la < 0 || lb < 0 {
+			return la >= 0 && lb < 0
+		}
+		return la < lb
+	})
+	sorted := make([]string, len(mirrors))
+	for i, j := range order {
+		sorted[i] = mirrors[j]
+	}
+	return sorted
+}
+
+//UrlGetToFileMirrors downloads the same file from the first mirror that works, in the given order or,
+//when probetimeout > 0, fastest first after probing every mirror with a HEAD request.
+//A mirror fails on a connection error, a status other than 2xx or, when checksum ("sha256:<hex>", see
+//ParseChecksum) is not empty or the response declares digests (see HeaderChecksums), a checksum
+//mismatch; filepath is only written by the mirror that succeeds.
+//mirror is the url that succeeded, attempts lists every mirror tried. httpretcode is the one of the
+//last attempt, 8 for a checksum mismatch, 3 for no mirror or a bad checksum, 6 if filepath cannot be written.
+func (c *Client) UrlGetToFileMirrors(mirrors []string, checksum string, probetimeout time.Duration, httpsendhead []string, cookie []*http.Cookie, filepath string, contimeout, datatrantimeout time.Duration) (head http.Header, mirror string, httpretcode int, attempts []MirrorAttempt) {
+	if len(mirrors) == 0 {
+		return http.Header{}, "", 3, nil
+	}
+	checksums, err := parseChecksums(checksum)
+	if err != nil {
+		return http.Header{}, "", 3, nil
+	}
+	if probetimeout > 0 && len(mirrors) > 1 {
+		mirrors = c.probeMirrors(mirrors, httpsendhead, cookie, probetimeout)
+	}
+
+	head = http.Header{}
+	for _, m := range mirrors {
+		start := time.Now()
+		response, code, _, err := c.fetchToFile(m, httpsendhead, cookie, filepath, checksums, true, contimeout, datatrantimeout)
+		attempts = append(attempts, MirrorAttempt{URL: m, HttpRetCode: code, Err: err, Duration: time.Since(start)})
+		head = http.Header{}
+		if response != nil {
+			head = response.Header
+		}
+		if err == nil && code >= 200 && code < 300 {
+			return head, m, code, attempts
+		}
+		httpretcode = code
+		if code == 6 {
+			//another mirror cannot fix a local file error
+			break
+		}
+	}
+	return head, "", httpretcode, attempts
+}
@@ -0,0 +1,105 @@
+// netutil project mirror_test.go
+package netutil
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//newContentServer answers content after delay.
+func newContentServer(t *testing.T, content string, delay time.Duration) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte(content))
+	})
+}
+
+func TestMirrorsFallback(t *testing.T) {
+	sum := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("artifact")))
+	notfound := newTestServer(t, http.NotFound)
+	corrupt := newContentServer(t, "corrupt", 0)
+	good := newContentServer(t, "artifact", 0)
+	dst := filepath.Join(t.TempDir(), "out.bin")
+
+	mirrors := []string{"http://127.0.0.1:1/x", notfound.URL, corrupt.URL, good.URL}
+	_, mirror, code, attempts := UrlGetToFileMirrors(mirrors, sum, 0, nil, nil, dst, time.Second, 5*time.Second)
+	if mirror != good.URL || code != 200 {
+		t.Fatalf("mirror %q httpretcode %d", mirror, code)
+	}
+	if len(attempts) != 4 {
+		t.Fatalf("%d attempts", len(attempts))
+	}
+	for i, want := range []int{2, 404, 8, 200} {
+		if attempts[i].URL != mirrors[i] || attempts[i].HttpRetCode != want {
+			t.Errorf("attempt %d: %s httpretcode %d, want %d", i, attempts[i].URL, attempts[i].HttpRetCode, want)
+		}
+	}
+	if attempts[0].Err == nil || attempts[1].Err != nil || attempts[2].Err == nil || attempts[3].Err != nil {
+		t.Errorf("attempt errors %v %v %v %v", attempts[0].Err, attempts[1].Err, attempts[2].Err, attempts[3].Err)
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "artifact" {
+		t.Errorf("file content %q", b)
+	}
+}
+
+//TestMirrorsAllFail checks filepath is left alone when no mirror works.
+func TestMirrorsAllFail(t *testing.T) {
+	sum := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("artifact")))
+	corrupt := newContentServer(t, "corrupt", 0)
+	dir := t.TempDir()
+	dst := writeTestFile(t, filepath.Join(dir, "out.bin"), "previous")
+
+	_, mirror, code, attempts := UrlGetToFileMirrors([]string{corrupt.URL, corrupt.URL}, sum, 0, nil, nil, dst, time.Second, 5*time.Second)
+	if mirror != "" || code != 8 || len(attempts) != 2 {
+		t.Errorf("mirror %q httpretcode %d, %d attempts", mirror, code, len(attempts))
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "previous" {
+		t.Errorf("file content %q", b)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files left in the directory", len(files))
+	}
+
+	if _, _, code, attempts := UrlGetToFileMirrors(nil, "", 0, nil, nil, dst, time.Second, 5*time.Second); code != 3 || attempts != nil {
+		t.Errorf("no mirror: httpretcode %d", code)
+	}
+	if _, _, code, _ := UrlGetToFileMirrors([]string{corrupt.URL}, "md5:zz", 0, nil, nil, dst, time.Second, 5*time.Second); code != 3 {
+		t.Errorf("bad checksum: httpretcode %d", code)
+	}
+	//a file which cannot be written stops at the first mirror
+	missing := filepath.Join(dir, "missing", "out.bin")
+	if _, _, code, attempts := UrlGetToFileMirrors([]string{corrupt.URL, corrupt.URL}, "", 0, nil, nil, missing, time.Second, 5*time.Second); code != 6 || len(attempts) != 1 {
+		t.Errorf("unwritable file: httpretcode %d, %d attempts", code, len(attempts))
+	}
+}
+
+func TestMirrorsProbe(t *testing.T) {
+	slow := newContentServer(t, "slow", 200*time.Millisecond)
+	fast := newContentServer(t, "fast", 0)
+	notfound := newTestServer(t, http.NotFound)
+	dst := filepath.Join(t.TempDir(), "out.bin")
+
+	mirrors := []string{notfound.URL, slow.URL, fast.URL}
+	if sorted := (&Client{}).probeMirrors(mirrors, nil, nil, time.Second); fmt.Sprint(sorted) != fmt.Sprint([]string{fast.URL, slow.URL, notfound.URL}) {
+		t.Errorf("probe order %v", sorted)
+	}
+	//the probes close their connections, not only once their deadline passed
+	(&Client{}).probeMirrors(mirrors, nil, nil, 5*time.Second)
+	for _, srv := range []*testServer{slow, fast, notfound} {
+		if !srv.closed() {
+			t.Errorf("a probe connection to %s is left open", srv.URL)
+		}
+	}
+	_, mirror, code, attempts := UrlGetToFileMirrors(mirrors, "", time.Second, nil, nil, dst, time.Second, 5*time.Second)
+	if mirror != fast.URL || code != 200 || len(attempts) != 1 {
+		t.Errorf("mirror %q httpretcode %d, %d attempts", mirror, code, len(attempts))
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "fast" {
+		t.Errorf("file content %q", b)
+	}
+}
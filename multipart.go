@@ -0,0 +1,381 @@
+// netutil project multipart.go
+package netutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Multipart is a multipart/form-data body whose parts are declared explicitly
+//as plain fields or as files. Unlike UrlPostWithFile nothing is guessed from
+//the value, so a field value that happens to be a file path is sent as text.
+type Multipart struct {
+	Parts []*Part
+}
+
+//Part is one part of a Multipart body. FileName is empty for plain fields.
+//the Content-Type of a file part is detected unless ContentType or Header sets it.
+type Part struct {
+	Name        string
+	Value       string
+	FileName    string
+	ContentType string
+	Header      textproto.MIMEHeader
+
+	isfile bool
+	path   string
+	reader io.Reader
+	data   []byte
+	size   int64
+	//detected content type and the bytes read from reader to sniff it
+	detected string
+	sniffed  []byte
+}
+
+func NewMultipart() *Multipart {
+	return &Multipart{}
+}
+
+//AddField adds a plain form field.
+func (m *Multipart) AddField(name, value string) *Part {
+	p := &Part{Name: name, Value: value}
+	m.Parts = append(m.Parts, p)
+	return p
+}
+
+//AddFile adds a file part read from path, the filename defaults to the base name of path.
+func (m *Multipart) AddFile(name, path string) *Part {
+	p := &Part{Name: name, FileName: filepath.Base(path), isfile: true, path: path}
+	m.Parts = append(m.Parts, p)
+	return p
+}
+
+//AddReader adds a file part whose content is read from r.
+//the size is unknown unless r is a bytes or strings reader or SetSize is called.
+func (m *Multipart) AddReader(name, filename string, r io.Reader) *Part {
+	p := &Part{Name: name, FileName: filename, isfile: true, reader: r, size: -1}
+	switch lr := r.(type) {
+	case *bytes.Reader:
+		p.size = int64(lr.Len())
+	case *strings.Reader:
+		p.size = int64(lr.Len())
+	case *bytes.Buffer:
+		p.size = int64(lr.Len())
+	}
+	m.Parts = append(m.Parts, p)
+	return p
+}
+
+//AddBytes adds a file part whose content is data.
+func (m *Multipart) AddBytes(name, filename string, data []byte) *Part {
+	p := &Part{Name: name, FileName: filename, isfile: true, data: data}
+	m.Parts = append(m.Parts, p)
+	return p
+}
+
+func (p *Part) SetFileName(filename string) *Part {
+	p.FileName = filename
+	return p
+}
+
+func (p *Part) SetContentType(contenttype string) *Part {
+	p.ContentType = contenttype
+	return p
+}
+
+//SetSize sets the content size of a reader part, so the request can carry Content-Length.
+func (p *Part) SetSize(size int64) *Part {
+	p.size = size
+	return p
+}
+
+//SetHeader sets an extra header of the part, Content-Disposition is always generated.
+func (p *Part) SetHeader(key, value string) *Part {
+	if p.Header == nil {
+		p.Header = textproto.MIMEHeader{}
+	}
+	p.Header.Set(key, value)
+	return p
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func (p *Part) mimeHeader() textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	for k, v := range p.Header {
+		h[k] = v
+	}
+	disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(p.Name))
+	if p.isfile {
+		disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(p.FileName))
+	}
+	h.Set("Content-Disposition", disposition)
+	if p.ContentType != "" {
+		h.Set("Content-Type", p.ContentType)
+	} else if p.isfile && h.Get("Content-Type") == "" {
+		h.Set("Content-Type", p.detectContentType())
+	}
+	return h
+}
+
+//detectContentType guesses the type of a file part from the filename extension,
+//failing that from the first 512 bytes of the content.
+func (p *Part) detectContentType() string {
+	if p.detected != "" {
+		return p.detected
+	}
+	p.detected = mime.TypeByExtension(filepath.Ext(p.FileName))
+	if p.detected != "" {
+		return p.detected
+	}
+	var head []byte
+	switch {
+	case p.reader != nil:
+		//keep the sniffed bytes, they are written before the rest of the reader
+		buf := make([]byte, 512)
+		n, _ := io.ReadFull(p.reader, buf)
+		p.sniffed = buf[:n]
+		head = p.sniffed
+	case p.data != nil:
+		head = p.data
+	case p.path != "":
+		if fd, err := os.Open(p.path); err == nil {
+			buf := make([]byte, 512)
+			n, _ := io.ReadFull(fd, buf)
+			fd.Close()
+			head = buf[:n]
+		}
+	}
+	if len(head) == 0 {
+		p.detected = "application/octet-stream"
+	} else {
+		p.detected = http.DetectContentType(head)
+	}
+	return p.detected
+}
+
+//multipart write error, the code is the UrlPostWithFile httpretcode.
+type multipartError struct {
+	code int
+	err  error
+}
+
+func (e *multipartError) Error() string {
+	return e.err.Error()
+}
+
+func (p *Part) writeTo(w *multipart.Writer) error {
+	pw, err := w.CreatePart(p.mimeHeader())
+	if err != nil {
+		return &multipartError{10, err}
+	}
+	var src io.Reader
+	switch {
+	case !p.isfile:
+		src = strings.NewReader(p.Value)
+	case p.reader != nil:
+		src = io.MultiReader(bytes.NewReader(p.sniffed), p.reader)
+	case p.data != nil:
+		src = bytes.NewReader(p.data)
+	case p.path != "":
+		fd, err := os.Open(p.path)
+		if err != nil {
+			return &multipartError{11, err}
+		}
+		defer fd.Close()
+		src = fd
+	default:
+		src = strings.NewReader("")
+	}
+	if _, err = io.Copy(pw, src); err != nil {
+		return &multipartError{12, err}
+	}
+	return nil
+}
+
+//contentSize returns the size of the part content, -1 if unknown.
+func (p *Part) contentSize() int64 {
+	switch {
+	case !p.isfile:
+		return int64(len(p.Value))
+	case p.reader != nil:
+		return p.size
+	case p.data != nil:
+		return int64(len(p.data))
+	case p.path != "":
+		fi, err := os.Stat(p.path)
+		if err != nil || !fi.Mode().IsRegular() {
+			return -1
+		}
+		return fi.Size()
+	}
+	return 0
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+//ContentLength returns the encoded body length with the given boundary, -1 if some part size is unknown.
+func (m *Multipart) ContentLength(boundary string) int64 {
+	cw := &countWriter{}
+	w := multipart.NewWriter(cw)
+	if err := w.SetBoundary(boundary); err != nil {
+		return -1
+	}
+	var length int64
+	for _, p := range m.Parts {
+		size := p.contentSize()
+		if size < 0 {
+			return -1
+		}
+		if _, err := w.CreatePart(p.mimeHeader()); err != nil {
+			return -1
+		}
+		length += size
+	}
+	w.Close()
+	return length + cw.n
+}
+
+//pipe streams the body through an io.Pipe, the write error is sent on errc before the pipe is closed.
+func (m *Multipart) pipe() (body *io.PipeReader, contenttype string, length int64, errc chan error) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	length = m.ContentLength(w.Boundary())
+	errc = make(chan error, 1)
+	go func() {
+		err := m.WriteTo(w)
+		errc <- err
+		pw.CloseWithError(err)
+	}()
+	return pr, w.FormDataContentType(), length, errc
+}
+
+//WriteTo writes all parts and the terminating boundary to w.
+func (m *Multipart) WriteTo(w *multipart.Writer) error {
+	for _, p := range m.Parts {
+		if err := p.writeTo(w); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func (c *Client) UrlPostMultipart(httpurl string, body *Multipart, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+	if body == nil {
+		return []byte(""), http.Header{}, nil, 3, redilocation
+	}
+	client := c.newHttpClient(contimeout, datatrantimeout, &redilocation)
+
+	//the body is streamed from the parts to the connection, not assembled in memory
+	pr, contenttype, length, errc := body.pipe()
+	request, err := http.NewRequest("POST", httpurl, pr)
+	if err != nil {
+		pr.Close()
+		fmt.Println(err)
+		return []byte(""), http.Header{}, nil, 1, redilocation
+	}
+	if length >= 0 {
+		request.ContentLength = length
+	}
+	setRequestHead(request, contenttype, httpsendhead, cookie)
+
+	response, err := c.do(client, request)
+	if err != nil {
+		pr.Close()
+		select {
+		case werr := <-errc:
+			if me, ok := werr.(*multipartError); ok {
+				return []byte(""), http.Header{}, nil, me.code, redilocation
+			}
+		default:
+		}
+		fmt.Println(err)
+		return []byte(""), http.Header{}, nil, doErrCode(err), redilocation
+	}
+	defer response.Body.Close()
+	if onlyhead {
+		return []byte(""), response.Header, response.Cookies(), response.StatusCode, redilocation
+	}
+	data, err := readResponseBody(response)
+	if err != nil {
+		if err == errUncompress {
+			return []byte(""), response.Header, response.Cookies(), 5, redilocation
+		}
+		return []byte(""), http.Header{}, nil, response.StatusCode, redilocation
+	}
+	return data, response.Header, response.Cookies(), response.StatusCode, redilocation
+}
+
+//matchGlob reports whether the slash separated relative path rel matches one of patterns,
+//a pattern without "/" is also tried against the base name.
+func matchGlob(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//AddDir adds every regular file below dir as a file part named name, the filename is the
+//slash separated path relative to dir. An empty include adds all files, exclude patterns
+//also skip whole directories.
+func (m *Multipart) AddDir(name, dir string, include, exclude []string) error {
+	return filepath.Walk(dir, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if matchGlob(exclude, rel) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() || (len(include) > 0 && !matchGlob(include, rel)) {
+			return nil
+		}
+		m.AddFile(name, fpath).SetFileName(rel)
+		return nil
+	})
+}
+
+//UrlPostDir uploads the files below dir as parts of the field name, see Multipart.AddDir.
+//the walk error returns httpretcode 11.
+func (c *Client) UrlPostDir(httpurl string, name, dir string, include, exclude []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+	body := NewMultipart()
+	if err := body.AddDir(name, dir, include, exclude); err != nil {
+		fmt.Println(err)
+		return []byte(""), http.Header{}, nil, 11, redilocation
+	}
+	return c.UrlPostMultipart(httpurl, body, onlyhead, httpsendhead, cookie, contimeout, datatrantimeout)
+}
@@ -0,0 +1,336 @@
+// netutil project multipart_test.go
+package netutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//receivedPart is a part as the test server saw it.
+type receivedPart struct {
+	Name        string
+	FileName    string
+	ContentType string
+	Header      map[string][]string
+	Body        string
+}
+
+type receivedUpload struct {
+	ContentLength    int64
+	TransferEncoding []string
+	Parts            []receivedPart
+}
+
+//newMultipartServer answers every multipart POST with the parts it read, in order, as JSON.
+func newMultipartServer(t *testing.T) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		up := receivedUpload{ContentLength: r.ContentLength, TransferEncoding: r.TransferEncoding}
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			body, _ := ioutil.ReadAll(p)
+			//Part.FileName drops the directories of the filename
+			_, params, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+			up.Parts = append(up.Parts, receivedPart{p.FormName(), params["filename"], p.Header.Get("Content-Type"), p.Header, string(body)})
+		}
+		json.NewEncoder(w).Encode(up)
+	})
+}
+
+func decodeUpload(t *testing.T, content []byte) receivedUpload {
+	var up receivedUpload
+	if err := json.Unmarshal(content, &up); err != nil {
+		t.Fatalf("bad server answer %q: %v", content, err)
+	}
+	return up
+}
+
+func writeTestFile(t *testing.T, path, content string) string {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUrlPostMultipartExplicitParts(t *testing.T) {
+	srv := newMultipartServer(t)
+	file := writeTestFile(t, filepath.Join(t.TempDir(), "report.bin"), "file content")
+
+	m := NewMultipart()
+	//a value naming an existing file is still a plain field
+	m.AddField("comment", file)
+	m.AddBytes("data", "a.txt", []byte("hello")).SetContentType("text/plain").SetHeader("X-Part", "1")
+	m.AddFile("upload", file)
+	m.AddFile("renamed", file).SetFileName("other.bin")
+	content, _, _, code, _ := UrlPostMultipart(srv.URL, m, false, nil, nil, time.Second, 5*time.Second)
+	if code != 200 {
+		t.Fatalf("httpretcode %d, want 200", code)
+	}
+	up := decodeUpload(t, content)
+	want := []receivedPart{
+		{Name: "comment", Body: file},
+		{Name: "data", FileName: "a.txt", ContentType: "text/plain", Body: "hello"},
+		{Name: "upload", FileName: "report.bin", Body: "file content"},
+		{Name: "renamed", FileName: "other.bin", Body: "file content"},
+	}
+	if len(up.Parts) != len(want) {
+		t.Fatalf("got %d parts, want %d: %+v", len(up.Parts), len(want), up.Parts)
+	}
+	for i, w := range want {
+		got := up.Parts[i]
+		if got.Name != w.Name || got.FileName != w.FileName || got.Body != w.Body {
+			t.Errorf("part %d = %q %q %q, want %q %q %q", i, got.Name, got.FileName, got.Body, w.Name, w.FileName, w.Body)
+		}
+		if w.ContentType != "" && got.ContentType != w.ContentType {
+			t.Errorf("part %d Content-Type %q, want %q", i, got.ContentType, w.ContentType)
+		}
+	}
+	if up.Parts[0].ContentType != "" {
+		t.Errorf("plain field has Content-Type %q", up.Parts[0].ContentType)
+	}
+	if got := up.Parts[1].Header["X-Part"]; len(got) != 1 || got[0] != "1" {
+		t.Errorf("X-Part header %q, want 1", got)
+	}
+}
+
+func TestUrlPostWithFileStillUploadsPaths(t *testing.T) {
+	srv := newMultipartServer(t)
+	file := writeTestFile(t, filepath.Join(t.TempDir(), "f.txt"), "abc")
+	content, _, _, code, _ := UrlPostWithFile(srv.URL, []string{"file", file, "name", "value"}, false, nil, nil, time.Second, 5*time.Second)
+	if code != 200 {
+		t.Fatalf("httpretcode %d, want 200", code)
+	}
+	up := decodeUpload(t, content)
+	if len(up.Parts) != 2 || up.Parts[0].FileName != "f.txt" || up.Parts[0].Body != "abc" || up.Parts[1].FileName != "" || up.Parts[1].Body != "value" {
+		t.Fatalf("unexpected parts %+v", up.Parts)
+	}
+	if _, _, _, code, _ = UrlPostWithFile(srv.URL, []string{"odd"}, false, nil, nil, time.Second, time.Second); code != 3 {
+		t.Errorf("odd postdata httpretcode %d, want 3", code)
+	}
+}
+
+func TestUrlPostMultipartNilBody(t *testing.T) {
+	if _, _, _, code, _ := UrlPostMultipart("http://127.0.0.1:1/", nil, false, nil, nil, time.Second, time.Second); code != 3 {
+		t.Errorf("httpretcode %d, want 3", code)
+	}
+}
+
+func TestMultipartWriteTo(t *testing.T) {
+	m := NewMultipart()
+	m.AddField("a", "1")
+	m.AddBytes("b", `q"uote.txt`, []byte("x")).SetContentType("text/plain")
+	out := &bytes.Buffer{}
+	w := multipart.NewWriter(out)
+	if err := m.WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(w.FormDataContentType())
+	if err != nil || params["boundary"] != w.Boundary() {
+		t.Fatalf("content type %q: %v", w.FormDataContentType(), err)
+	}
+	r := multipart.NewReader(out, w.Boundary())
+	p, err := r.NextPart()
+	if err != nil || p.FormName() != "a" {
+		t.Fatalf("first part %v %v", p, err)
+	}
+	if p, err = r.NextPart(); err != nil || p.FileName() != `q"uote.txt` {
+		t.Fatalf("second part %v %v", p, err)
+	}
+}
+
+func TestUrlPostMultipartContentLength(t *testing.T) {
+	srv := newMultipartServer(t)
+	file := writeTestFile(t, filepath.Join(t.TempDir(), "big.bin"), strings.Repeat("x", 1<<20))
+
+	m := NewMultipart()
+	m.AddField("a", "b")
+	m.AddFile("f", file)
+	m.AddReader("r", "r.txt", strings.NewReader("known"))
+	content, _, _, code, _ := UrlPostMultipart(srv.URL, m, false, nil, nil, time.Second, 5*time.Second)
+	up := decodeUpload(t, content)
+	if code != 200 || up.ContentLength <= 1<<20 || len(up.TransferEncoding) != 0 {
+		t.Fatalf("known sizes: code %d Content-Length %d Transfer-Encoding %v", code, up.ContentLength, up.TransferEncoding)
+	}
+	if len(up.Parts) != 3 || len(up.Parts[1].Body) != 1<<20 || up.Parts[2].Body != "known" {
+		t.Fatalf("parts not received intact")
+	}
+
+	//a reader of unknown size is sent chunked, unless SetSize tells its size
+	m = NewMultipart()
+	m.AddReader("r", "r.txt", io.LimitReader(strings.NewReader("unknown size"), 100))
+	content, _, _, code, _ = UrlPostMultipart(srv.URL, m, false, nil, nil, time.Second, 5*time.Second)
+	up = decodeUpload(t, content)
+	if code != 200 || up.ContentLength != -1 || len(up.TransferEncoding) != 1 || up.TransferEncoding[0] != "chunked" {
+		t.Fatalf("unknown size: code %d Content-Length %d Transfer-Encoding %v", code, up.ContentLength, up.TransferEncoding)
+	}
+	if up.Parts[0].Body != "unknown size" {
+		t.Fatalf("body %q", up.Parts[0].Body)
+	}
+	m = NewMultipart()
+	m.AddReader("r", "r.txt", io.LimitReader(strings.NewReader("sized"), 100)).SetSize(5)
+	content, _, _, code, _ = UrlPostMultipart(srv.URL, m, false, nil, nil, time.Second, 5*time.Second)
+	if up = decodeUpload(t, content); code != 200 || up.ContentLength <= 0 || up.Parts[0].Body != "sized" {
+		t.Fatalf("SetSize: code %d Content-Length %d", code, up.ContentLength)
+	}
+}
+
+func TestUrlPostMultipartStreams(t *testing.T) {
+	first := strings.Repeat("a", 256<<10)
+	received := make(chan struct{})
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := mr.NextPart()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		buf := make([]byte, len(first))
+		if _, err = io.ReadFull(p, buf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		close(received)
+		rest, _ := ioutil.ReadAll(p)
+		w.Write(rest)
+	})
+
+	//the end of the body is only written after the server got its beginning
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte(first))
+		select {
+		case <-received:
+			pw.Write([]byte("rest"))
+			pw.Close()
+		case <-time.After(5 * time.Second):
+			pw.CloseWithError(errors.New("body was buffered"))
+		}
+	}()
+	m := NewMultipart()
+	m.AddReader("stream", "s.bin", pr)
+	content, _, _, code, _ := UrlPostMultipart(srv.URL, m, false, nil, nil, time.Second, 10*time.Second)
+	if code != 200 || string(content) != "rest" {
+		t.Fatalf("httpretcode %d content %q", code, content)
+	}
+}
+
+func TestUrlPostMultipartMissingFile(t *testing.T) {
+	srv := newMultipartServer(t)
+	m := NewMultipart()
+	m.AddField("a", "b")
+	m.AddFile("f", filepath.Join(t.TempDir(), "missing"))
+	if _, _, _, code, _ := UrlPostMultipart(srv.URL, m, false, nil, nil, time.Second, 5*time.Second); code != 11 {
+		t.Errorf("httpretcode %d, want 11", code)
+	}
+}
+
+func TestMultipartContentTypeDetection(t *testing.T) {
+	srv := newMultipartServer(t)
+	png := writeTestFile(t, filepath.Join(t.TempDir(), "image.png"), "not really a png")
+	html := "<!DOCTYPE html><html><body>hi</body></html>"
+
+	m := NewMultipart()
+	m.AddFile("byext", png)
+	m.AddBytes("sniffed", "noext", []byte("%PDF-1.4 document"))
+	//the sniffed bytes of a reader are still sent
+	m.AddReader("reader", "page", io.LimitReader(strings.NewReader(html), 1000))
+	m.AddBytes("empty", "empty", []byte{})
+	m.AddBytes("explicit", "data.png", []byte("x")).SetContentType("application/x-custom")
+	m.AddBytes("header", "data.png", []byte("x")).SetHeader("Content-Type", "text/x-header")
+	content, _, _, code, _ := UrlPostMultipart(srv.URL, m, false, nil, nil, time.Second, 5*time.Second)
+	if code != 200 {
+		t.Fatalf("httpretcode %d", code)
+	}
+	up := decodeUpload(t, content)
+	want := []string{"image/png", "application/pdf", "text/html; charset=utf-8", "application/octet-stream", "application/x-custom", "text/x-header"}
+	if len(up.Parts) != len(want) {
+		t.Fatalf("got %d parts", len(up.Parts))
+	}
+	for i, ct := range want {
+		if up.Parts[i].ContentType != ct {
+			t.Errorf("part %s Content-Type %q, want %q", up.Parts[i].Name, up.Parts[i].ContentType, ct)
+		}
+	}
+	if up.Parts[2].Body != html {
+		t.Errorf("reader part body %q, want %q", up.Parts[2].Body, html)
+	}
+}
+
+func TestUrlPostDir(t *testing.T) {
+	srv := newMultipartServer(t)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "a")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "b")
+	writeTestFile(t, filepath.Join(dir, "sub", "c.log"), "c")
+	writeTestFile(t, filepath.Join(dir, "skip", "d.txt"), "d")
+	writeTestFile(t, filepath.Join(dir, "sub", "deep", "e.txt"), "e")
+
+	content, _, _, code, _ := UrlPostDir(srv.URL, "files", dir, []string{"*.txt"}, []string{"skip", "sub/deep/*"}, false, nil, nil, time.Second, 5*time.Second)
+	if code != 200 {
+		t.Fatalf("httpretcode %d", code)
+	}
+	got := map[string]string{}
+	for _, p := range decodeUpload(t, content).Parts {
+		if p.Name != "files" {
+			t.Errorf("part name %q, want files", p.Name)
+		}
+		got[p.FileName] = p.Body
+	}
+	want := map[string]string{"a.txt": "a", "sub/b.txt": "b"}
+	if len(got) != len(want) {
+		t.Fatalf("uploaded %v, want %v", got, want)
+	}
+	for name, body := range want {
+		if got[name] != body {
+			t.Errorf("%s = %q, want %q", name, got[name], body)
+		}
+	}
+}
+
+func TestMultipartAddDirAll(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "x", "y", "z.bin"), "z")
+	writeTestFile(t, filepath.Join(dir, "top"), "t")
+	m := NewMultipart()
+	if err := m.AddDir("f", dir, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range m.Parts {
+		names = append(names, p.FileName)
+	}
+	if strings.Join(names, ",") != "top,x/y/z.bin" {
+		t.Errorf("files %v", names)
+	}
+	if err := m.AddDir("f", filepath.Join(dir, "missing"), nil, nil); err == nil {
+		t.Error("no error for a missing directory")
+	}
+	if _, _, _, code, _ := UrlPostDir("http://127.0.0.1:1/", "f", filepath.Join(dir, "missing"), nil, nil, false, nil, nil, time.Second, time.Second); code != 11 {
+		t.Errorf("missing directory httpretcode %d, want 11", code)
+	}
+}
@@ -5,20 +5,22 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"mime/multipart"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -60,17 +62,10 @@ func UncompressWithName(data []byte, name string) ([]byte, error) {
 	return []byte(""), err
 }
 
-//httpgetdata format name follow value sequence.
-func UrlGet(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration, outbuf []byte, getctt_contenttype_regex ...string) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+func (c *Client) UrlGet(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration, outbuf []byte, getctt_contenttype_regex ...string) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
 	if len(httpgetdata)%2 != 0 {
 		return []byte(""), http.Header{}, nil, 3, redilocation
 	}
-	if contimeout <= 0 {
-		contimeout = 36500 * 24 * 3600 * time.Second
-	}
-	if datatrantimeout <= 0 {
-		datatrantimeout = 36500 * 24 * 3600 * time.Second
-	}
 
 	urlparam := url.Values{}
 	getdatai := 0
@@ -90,60 +85,19 @@ func UrlGet(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []
 		}
 	}
 
-	client := &http.Client{Transport: &http.Transport{
-		Dial: func(netw, addr string) (net.Conn, error) {
-			c, err := net.DialTimeout(netw, addr, contimeout) //设置建立连接超时
-			if err != nil {
-				return nil, err
-			}
-			c.SetDeadline(time.Now().Add(datatrantimeout)) //设置发送接收数据超时
-			return c, nil
-		},
-	},
-	}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		if len(via) >= 10 {
-			return errors.New("stopped after 10 redirects")
-		}
-		redilocation = req.URL.String()
-		return nil
-	}
+	client := c.newHttpClient(contimeout, datatrantimeout, &redilocation)
 	request, err := http.NewRequest("GET", httpurl, nil)
 	if err != nil {
 		fmt.Println(err)
 		return []byte(""), http.Header{}, nil, 1, redilocation
 	}
-	//cookie := &http.Cookie{Name: "userId", Value: strconv.Itoa(12345)}
-	if cookie != nil {
-		for _, ck := range cookie {
-			request.AddCookie(ck) //request中添加cookie
-		}
-	}
-
 	//设置request的header
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	httpsendheadi := 0
-	haveacceptencoding := false
-	for httpsendheadi < len(httpsendhead) {
-		if httpsendhead[httpsendheadi] == "Accept-Encoding" {
-			haveacceptencoding = true
-		}
-		if httpsendhead[httpsendheadi+1] != "" {
-			request.Header.Set(httpsendhead[httpsendheadi], httpsendhead[httpsendheadi+1])
-		}
-		httpsendheadi += 2
-		if httpsendheadi >= len(httpsendhead) {
-			break
-		}
-	}
-	if haveacceptencoding == false {
-		request.Header.Set("Accept-Encoding", "gzip,deflate")
-	}
+	setRequestHead(request, "application/x-www-form-urlencoded", httpsendhead, cookie)
 	//fmt.Println("UrlGet client.Do(request) 1")
-	response, err := client.Do(request)
+	response, err := c.do(client, request)
 	if err != nil {
 		fmt.Println(err)
-		return []byte(""), http.Header{}, nil, 2, redilocation
+		return []byte(""), http.Header{}, nil, doErrCode(err), redilocation
 	}
 	//fmt.Println("UrlGet client.Do(request) 1 end")
 
@@ -190,16 +144,10 @@ func UrlGet(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []
 	return []byte(""), http.Header{}, nil, response.StatusCode, redilocation
 }
 
-func UrlPost(httpurl string, postdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+func (c *Client) UrlPost(httpurl string, postdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
 	if len(postdata)%2 != 0 {
 		return []byte(""), http.Header{}, nil, 3, redilocation
 	}
-	if contimeout <= 0 {
-		contimeout = 36500 * 24 * 3600 * time.Second
-	}
-	if datatrantimeout <= 0 {
-		datatrantimeout = 36500 * 24 * 3600 * time.Second
-	}
 
 	urlparam := url.Values{}
 	postdatai := 0
@@ -212,24 +160,7 @@ func UrlPost(httpurl string, postdata []string, onlyhead bool, httpsendhead []st
 	}
 	urlparamstr := urlparam.Encode()
 
-	client := &http.Client{Transport: &http.Transport{
-		Dial: func(netw, addr string) (net.Conn, error) {
-			c, err := net.DialTimeout(netw, addr, contimeout) //设置建立连接超时
-			if err != nil {
-				return nil, err
-			}
-			c.SetDeadline(time.Now().Add(datatrantimeout)) //设置发送接收数据超时
-			return c, nil
-		},
-	},
-	}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		if len(via) >= 10 {
-			return errors.New("stopped after 10 redirects")
-		}
-		redilocation = req.URL.String()
-		return nil
-	}
+	client := c.newHttpClient(contimeout, datatrantimeout, &redilocation)
 
 	request, err := http.NewRequest("POST",
 		httpurl,
@@ -239,37 +170,13 @@ func UrlPost(httpurl string, postdata []string, onlyhead bool, httpsendhead []st
 		fmt.Println(err)
 		return []byte(""), http.Header{}, nil, 1, redilocation
 	}
-	//cookie := &http.Cookie{Name: "userId", Value: strconv.Itoa(12345)}
-	if cookie != nil {
-		for _, ck := range cookie {
-			request.AddCookie(ck) //request中添加cookie
-		}
-	}
-
 	//设置request的header
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	httpsendheadi := 0
-	haveacceptencoding := false
-	for httpsendheadi < len(httpsendhead) {
-		if httpsendhead[httpsendheadi] == "Accept-Encoding" {
-			haveacceptencoding = true
-		}
-		if httpsendhead[httpsendheadi+1] != "" {
-			request.Header.Set(httpsendhead[httpsendheadi], httpsendhead[httpsendheadi+1])
-		}
-		httpsendheadi += 2
-		if httpsendheadi >= len(httpsendhead) {
-			break
-		}
-	}
-	if haveacceptencoding == false {
-		request.Header.Set("Accept-Encoding", "gzip,deflate")
-	}
+	setRequestHead(request, "application/x-www-form-urlencoded", httpsendhead, cookie)
 
-	response, err := client.Do(request)
+	response, err := c.do(client, request)
 	if err != nil {
 		fmt.Println(err)
-		return []byte(""), http.Header{}, nil, 2, redilocation
+		return []byte(""), http.Header{}, nil, doErrCode(err), redilocation
 	}
 
 	defer response.Body.Close()
@@ -298,67 +205,24 @@ func UrlPost(httpurl string, postdata []string, onlyhead bool, httpsendhead []st
 	return []byte(""), http.Header{}, nil, response.StatusCode, redilocation
 }
 
-//multi file upload in one segment need add postfield name with "[]"
-func UrlPostWithFile(httpurl string, postdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+//UrlPostWithFile posts postdata as multipart/form-data, any value which is an existing file path is uploaded as file.
+//
+//Deprecated: a value which happens to name a file is uploaded too, use UrlPostMultipart to declare file parts explicitly.
+func (c *Client) UrlPostWithFile(httpurl string, postdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
 	if len(postdata)%2 != 0 {
 		return []byte(""), http.Header{}, nil, 3, redilocation
 	}
-	if contimeout <= 0 {
-		contimeout = 36500 * 24 * 3600 * time.Second
-	}
-	if datatrantimeout <= 0 {
-		datatrantimeout = 36500 * 24 * 3600 * time.Second
-	}
-
-	client := &http.Client{Transport: &http.Transport{
-		Dial: func(netw, addr string) (net.Conn, error) {
-			c, err := net.DialTimeout(netw, addr, contimeout) //设置建立连接超时
-			if err != nil {
-				return nil, err
-			}
-			c.SetDeadline(time.Now().Add(datatrantimeout)) //设置发送接收数据超时
-			return c, nil
-		},
-	},
-	}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		if len(via) >= 10 {
-			return errors.New("stopped after 10 redirects")
-		}
-		redilocation = req.URL.String()
-		return nil
-	}
 
-	// Create buffer
-	buf := &bytes.Buffer{} // caveat IMO dont use this for large files, \
-	// create a tmpfile and assemble your multipart from there (not tested)
-	w := multipart.NewWriter(buf)
+	body := NewMultipart()
 	postdatai := 0
 	for postdatai < len(postdata) {
 		postfi, err := os.Stat(postdata[postdatai+1])
 		if err == nil && !postfi.IsDir() {
 			// Create file field
-			fw, err := w.CreateFormFile(postdata[postdatai], filepath.Base(postdata[postdatai+1])) //这里的file很重要，必须和服务器端的FormFile一致
-			if err != nil {
-				//fmt.Println("c")
-				return []byte(""), http.Header{}, nil, 10, redilocation
-			}
-			fd, err := os.Open(postdata[postdatai+1])
-			if err != nil {
-				//fmt.Println("d")
-				return []byte(""), http.Header{}, nil, 11, redilocation
-			}
-			defer fd.Close()
-			// Write file field from file to upload
-			_, err = io.Copy(fw, fd)
-			if err != nil {
-				//fmt.Println("e")
-				return []byte(""), http.Header{}, nil, 12, redilocation
-			}
-
+			body.AddFile(postdata[postdatai], postdata[postdatai+1]) //这里的file很重要，必须和服务器端的FormFile一致
 		} else {
 			//other post data
-			w.WriteField(postdata[postdatai], postdata[postdatai+1])
+			body.AddField(postdata[postdatai], postdata[postdatai+1])
 		}
 
 		postdatai += 2
@@ -366,88 +230,13 @@ func UrlPostWithFile(httpurl string, postdata []string, onlyhead bool, httpsendh
 			break
 		}
 	}
-	// Important if you do not close the multipart writer you will not have a
-	// terminating boundry
-	w.Close()
-
-	request, err := http.NewRequest("POST",
-		httpurl,
-		buf)
-
-	if err != nil {
-		fmt.Println(err)
-		return []byte(""), http.Header{}, nil, 1, redilocation
-	}
-
-	//cookie := &http.Cookie{Name: "userId", Value: strconv.Itoa(12345)}
-	if cookie != nil {
-		for _, ck := range cookie {
-			request.AddCookie(ck) //request中添加cookie
-		}
-	}
-
-	//设置request的header
-	request.Header.Set("Content-Type", w.FormDataContentType())
-	httpsendheadi := 0
-	haveacceptencoding := false
-	for httpsendheadi < len(httpsendhead) {
-		if httpsendhead[httpsendheadi] == "Accept-Encoding" {
-			haveacceptencoding = true
-		}
-		if httpsendhead[httpsendheadi+1] != "" {
-			request.Header.Set(httpsendhead[httpsendheadi], httpsendhead[httpsendheadi+1])
-		}
-		httpsendheadi += 2
-		if httpsendheadi >= len(httpsendhead) {
-			break
-		}
-	}
-	if haveacceptencoding == false {
-		request.Header.Set("Accept-Encoding", "gzip,deflate")
-	}
-
-	response, err := client.Do(request)
-	if err != nil {
-		fmt.Println(err)
-		return []byte(""), http.Header{}, nil, 2, redilocation
-	}
-
-	defer response.Body.Close()
-	if response.StatusCode == response.StatusCode {
-		//fmt.Println(reflect.TypeOf(response.Header))
-		//fmt.Println(response.Header)
-		//head, err := ioutil.ReadFile(response.Header)
-		if onlyhead {
-			return []byte(""), response.Header, response.Cookies(), response.StatusCode, redilocation
-		} else {
-			data, err := ioutil.ReadAll(response.Body)
-			if err == nil {
-				if response.Header.Get("Content-Encoding") == "" {
-					return data, response.Header, response.Cookies(), response.StatusCode, redilocation
-				} else {
-					undata, err := UncompressWithName(data, strings.ToLower(response.Header.Get("Content-Encoding")))
-					if err == nil {
-						return undata, response.Header, response.Cookies(), response.StatusCode, redilocation
-					} else {
-						return []byte(""), response.Header, nil, 5, redilocation
-					}
-				}
-			}
-		}
-	}
-	return []byte(""), http.Header{}, nil, response.StatusCode, redilocation
+	return c.UrlPostMultipart(httpurl, body, onlyhead, httpsendhead, cookie, contimeout, datatrantimeout)
 }
 
-func UrlGetToFile(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, filepath string, contimeout, datatrantimeout time.Duration) (head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+func (c *Client) UrlGetToFile(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, filepath string, contimeout, datatrantimeout time.Duration) (head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
 	if len(httpgetdata)%2 != 0 {
 		return http.Header{}, nil, 3, redilocation
 	}
-	if contimeout <= 0 {
-		contimeout = 36500 * 24 * 3600 * time.Second
-	}
-	if datatrantimeout <= 0 {
-		datatrantimeout = 36500 * 24 * 3600 * time.Second
-	}
 
 	urlparam := url.Values{}
 	getdatai := 0
@@ -467,64 +256,21 @@ func UrlGetToFile(httpurl string, httpgetdata []string, onlyhead bool, httpsendh
 		}
 	}
 
-	client := &http.Client{Transport: &http.Transport{
-		Dial: func(netw, addr string) (net.Conn, error) {
-			c, err := net.DialTimeout(netw, addr, contimeout) //设置建立连接超时
-			if err != nil {
-				return nil, err
-			}
-			c.SetDeadline(time.Now().Add(datatrantimeout)) //设置发送接收数据超时
-			return c, nil
-		},
-	},
-	}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		if len(via) >= 10 {
-			return errors.New("stopped after 10 redirects")
-		}
-		redilocation = req.URL.String()
-		return nil
-	}
+	client := c.newHttpClient(contimeout, datatrantimeout, &redilocation)
 
 	request, err := http.NewRequest("GET", httpurl, nil)
 	if err != nil {
 		fmt.Println(err)
 		return http.Header{}, nil, 1, redilocation
 	}
-	//cookie := &http.Cookie{Name: "userId", Value: strconv.Itoa(12345)}
-	//request.AddCookie(cookie) //request中添加cookie
-	//cookie := &http.Cookie{Name: "userId", Value: strconv.Itoa(12345)}
-	if cookie != nil {
-		for _, ck := range cookie {
-			request.AddCookie(ck) //request中添加cookie
-		}
-	}
-
 	//设置request的header
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setRequestHead(request, "application/x-www-form-urlencoded", httpsendhead, cookie)
 	//request.Header.Set("Range", "bytes="+strconv.FormatInt(stat.Size(), 10)+"-")
-	httpsendheadi := 0
-	haveacceptencoding := false
-	for httpsendheadi < len(httpsendhead) {
-		if httpsendhead[httpsendheadi] == "Accept-Encoding" {
-			haveacceptencoding = true
-		}
-		if httpsendhead[httpsendheadi+1] != "" {
-			request.Header.Set(httpsendhead[httpsendheadi], httpsendhead[httpsendheadi+1])
-		}
-		httpsendheadi += 2
-		if httpsendheadi >= len(httpsendhead) {
-			break
-		}
-	}
-	if haveacceptencoding == false {
-		request.Header.Set("Accept-Encoding", "gzip,deflate")
-	}
 
-	response, err := client.Do(request)
+	response, err := c.do(client, request)
 	if err != nil {
 		fmt.Println(err)
-		return http.Header{}, nil, 2, redilocation
+		return http.Header{}, nil, doErrCode(err), redilocation
 	}
 
 	defer response.Body.Close()
@@ -562,16 +308,10 @@ func UrlGetToFile(httpurl string, httpgetdata []string, onlyhead bool, httpsendh
 	return http.Header{}, nil, response.StatusCode, redilocation
 }
 
-func UrlGetWithRange(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, startpos, endpos int64, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
+func (c *Client) UrlGetWithRange(httpurl string, httpgetdata []string, onlyhead bool, httpsendhead []string, cookie []*http.Cookie, startpos, endpos int64, contimeout, datatrantimeout time.Duration) (content []byte, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string) {
 	if len(httpgetdata)%2 != 0 {
 		return []byte(""), http.Header{}, nil, 3, redilocation
 	}
-	if contimeout <= 0 {
-		contimeout = 36500 * 24 * 3600 * time.Second
-	}
-	if datatrantimeout <= 0 {
-		datatrantimeout = 36500 * 24 * 3600 * time.Second
-	}
 
 	urlparam := url.Values{}
 	getdatai := 0
@@ -582,63 +322,21 @@ func UrlGetWithRange(httpurl string, httpgetdata []string, onlyhead bool, httpse
 			break
 		}
 	}
-	client := &http.Client{Transport: &http.Transport{
-		Dial: func(netw, addr string) (net.Conn, error) {
-			c, err := net.DialTimeout(netw, addr, contimeout) //设置建立连接超时
-			if err != nil {
-				return nil, err
-			}
-			c.SetDeadline(time.Now().Add(datatrantimeout)) //设置发送接收数据超时
-			return c, nil
-		},
-	},
-	}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		if len(via) >= 10 {
-			return errors.New("stopped after 10 redirects")
-		}
-		redilocation = req.URL.String()
-		return nil
-	}
+	client := c.newHttpClient(contimeout, datatrantimeout, &redilocation)
 	request, err := http.NewRequest("GET", httpurl, nil)
 	if err != nil {
 		fmt.Println(err)
 		return []byte(""), http.Header{}, nil, 1, redilocation
 	}
-	//cookie := &http.Cookie{Name: "userId", Value: strconv.Itoa(12345)}
-	//request.AddCookie(cookie) //request中添加cookie
-	//cookie := &http.Cookie{Name: "userId", Value: strconv.Itoa(12345)}
-	if cookie != nil {
-		for _, ck := range cookie {
-			request.AddCookie(ck) //request中添加cookie
-		}
-	}
-
 	//设置request的header
 	request.Header.Set("Content-Encoding", "application/x-www-form-urlencoded")
 	request.Header.Set("Range", "bytes="+strconv.FormatInt(startpos, 10)+"-"+strconv.FormatInt(endpos, 10))
-	httpsendheadi := 0
-	haveacceptencoding := false
-	for httpsendheadi < len(httpsendhead) {
-		if httpsendhead[httpsendheadi] == "Accept-Encoding" {
-			haveacceptencoding = true
-		}
-		if httpsendhead[httpsendheadi+1] != "" {
-			request.Header.Set(httpsendhead[httpsendheadi], httpsendhead[httpsendheadi+1])
-		}
-		httpsendheadi += 2
-		if httpsendheadi >= len(httpsendhead) {
-			break
-		}
-	}
-	if haveacceptencoding == false {
-		request.Header.Set("Accept-Encoding", "gzip,deflate")
-	}
+	setRequestHead(request, "", httpsendhead, cookie)
 
-	response, err := client.Do(request)
+	response, err := c.do(client, request)
 	if err != nil {
 		fmt.Println(err)
-		return []byte(""), http.Header{}, nil, 2, redilocation
+		return []byte(""), http.Header{}, nil, doErrCode(err), redilocation
 	}
 
 	defer response.Body.Close()
@@ -692,3 +390,188 @@ func UrlEncode(namedata string) string {
 	}
 	return namedata
 }
+
+var errUncompress = errors.New("uncompress response body failed")
+
+//httpClient is the http.Client of one call with what the call records about its requests.
+type httpClient struct {
+	*http.Client
+	transport    *proxyTransport
+	mu           sync.Mutex
+	redirects    []RedirectHop
+	redilocation *string
+}
+
+//setLocation stores the last redirect location, requests sent in parallel share it.
+func (hc *httpClient) setLocation(location string) {
+	hc.mu.Lock()
+	*hc.redilocation = location
+	hc.mu.Unlock()
+}
+
+func (hc *httpClient) addRedirect(hop RedirectHop) {
+	hc.mu.Lock()
+	hc.redirects = append(hc.redirects, hop)
+	*hc.redilocation = hop.Location
+	hc.mu.Unlock()
+}
+
+//redirectChain returns the redirects followed so far.
+func (hc *httpClient) redirectChain() []RedirectHop {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return append([]RedirectHop(nil), hc.redirects...)
+}
+
+//CloseIdleConnections closes the kept-alive connections of a client sending several requests,
+//the wrapping transports do not pass the call on.
+func (hc *httpClient) CloseIdleConnections() {
+	hc.transport.CloseIdleConnections()
+}
+
+//deadlineTransport gives a reused connection the data timeout again, it is set when dialing.
+type deadlineTransport struct {
+	timeout time.Duration
+	next    http.RoundTripper
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		if info.Reused {
+			info.Conn.SetDeadline(time.Now().Add(t.timeout))
+		}
+	}}
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+//newHttpClient returns a client with the dial and data timeouts used by all Url* functions,
+//redilocation receives the last redirect location.
+func (c *Client) newHttpClient(contimeout, datatrantimeout time.Duration, redilocation *string) *httpClient {
+	var timeouts Timeouts
+	var dial dialFunc
+	var renew time.Duration
+	if c.Timeouts != nil {
+		timeouts = *c.Timeouts
+		if timeouts.Dial <= 0 && contimeout > 0 {
+			timeouts.Dial = contimeout
+		}
+		if timeouts.Idle <= 0 && datatrantimeout > 0 {
+			timeouts.Idle = datatrantimeout
+		}
+		dial = func(ctx context.Context, netw, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{Timeout: timeouts.Dial}).DialContext(ctx, netw, addr)
+			if err != nil {
+				return nil, err
+			}
+			if timeouts.Idle > 0 {
+				return &idleConn{conn, timeouts.Idle}, nil
+			}
+			return conn, nil
+		}
+	} else {
+		if contimeout <= 0 {
+			contimeout = 36500 * 24 * 3600 * time.Second
+		}
+		if datatrantimeout <= 0 {
+			datatrantimeout = 36500 * 24 * 3600 * time.Second
+		}
+		dial = func(ctx context.Context, netw, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{Timeout: contimeout}).DialContext(ctx, netw, addr) //设置建立连接超时
+			if err != nil {
+				return nil, err
+			}
+			conn.SetDeadline(time.Now().Add(datatrantimeout)) //设置发送接收数据超时
+			return conn, nil
+		}
+		renew = datatrantimeout
+	}
+	var tlsconfig *tls.Config
+	if c.TLS != nil {
+		var err error
+		if tlsconfig, err = c.TLS.Config(); err != nil {
+			//every request of the call fails with the configuration error
+			dial = func(ctx context.Context, netw, addr string) (net.Conn, error) {
+				return nil, err
+			}
+		}
+	}
+	hc := &httpClient{redilocation: redilocation}
+	hc.transport = &proxyTransport{c: c, newTransport: func(proxy *url.URL) *http.Transport {
+		transport := &http.Transport{
+			TLSClientConfig:       tlsconfig,
+			TLSHandshakeTimeout:   timeouts.TLSHandshake,
+			ResponseHeaderTimeout: timeouts.ResponseHeader,
+			DialContext:           dial,
+		}
+		if proxy != nil {
+			useProxy(transport, proxy, dial)
+		}
+		return transport
+	}}
+	client := &http.Client{Timeout: timeouts.Request, Transport: hc.transport}
+	if renew > 0 {
+		client.Transport = &deadlineTransport{renew, client.Transport}
+	}
+	if c.HostLimit != nil {
+		client.Transport = &hostLimitTransport{c.HostLimit, client.Transport}
+	}
+	if c.Breaker != nil {
+		//outside the host limit, so an open circuit does not wait for a slot
+		client.Transport = &breakerTransport{c.Breaker, client.Transport}
+	}
+	if c.Hedge != nil {
+		//every hedged copy goes through the circuit breaker and the host limit on its own
+		client.Transport = &hedgeTransport{c.Hedge, client.Transport}
+	}
+	if c.Cache != nil {
+		//outside the hedge and limits, a fresh response needs no connection, slot or hedge
+		client.Transport = &cacheTransport{c.Cache, client.Transport}
+	}
+	if c.SingleFlight != nil {
+		client.Transport = &singleFlightTransport{c.SingleFlight, client.Transport}
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return c.checkRedirect(hc, req, via)
+	}
+	hc.Client = client
+	return hc
+}
+
+//setRequestHead sets Content-Type when not empty, cookies and the name/value sequence httpsendhead on request.
+func setRequestHead(request *http.Request, contenttype string, httpsendhead []string, cookie []*http.Cookie) {
+	for _, ck := range cookie {
+		request.AddCookie(ck) //request中添加cookie
+	}
+	if contenttype != "" {
+		request.Header.Set("Content-Type", contenttype)
+	}
+	haveacceptencoding := false
+	for i := 0; i+1 < len(httpsendhead); i += 2 {
+		if httpsendhead[i] == "Accept-Encoding" {
+			haveacceptencoding = true
+		}
+		if httpsendhead[i+1] != "" {
+			request.Header.Set(httpsendhead[i], httpsendhead[i+1])
+		}
+	}
+	if haveacceptencoding == false {
+		request.Header.Set("Accept-Encoding", "gzip,deflate")
+	}
+}
+
+//readResponseBody reads and uncompresses the response body.
+func readResponseBody(response *http.Response) ([]byte, error) {
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	encodeingname := response.Header.Get("Content-Encoding")
+	if encodeingname == "" {
+		return data, nil
+	}
+	undata, err := UncompressWithName(data, strings.ToLower(encodeingname))
+	if err != nil {
+		return nil, errUncompress
+	}
+	return undata, nil
+}
@@ -0,0 +1,78 @@
+// netutil project pin.go
+package netutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+)
+
+//PinError is the handshake error when no certificate of the verified server chain matches the pins of the host.
+type PinError struct {
+	Host string
+	//Pins are the configured pins, Got the pins of the presented chain.
+	Pins []string
+	Got  []string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("tls: public key pin mismatch for %s, got %s", e.Host, strings.Join(e.Got, ","))
+}
+
+//SPKIPin returns the base64 encoded SHA-256 of the certificate public key, the pin format of TLSOptions.Pins.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+//pinsFor returns the pins of the lower case host, an exact host entry wins over a "*.domain" entry.
+func (o *TLSOptions) pinsFor(host string) []string {
+	if pins, ok := o.pins[host]; ok {
+		return pins
+	}
+	if i := strings.Index(host, "."); i > 0 {
+		return o.pins["*"+host[i:]]
+	}
+	return nil
+}
+
+//verifyPins is the tls.Config VerifyConnection of the options when pins are set.
+func (o *TLSOptions) verifyPins(cs tls.ConnectionState) error {
+	pins := o.pinsFor(strings.ToLower(cs.ServerName))
+	if len(pins) == 0 {
+		return nil
+	}
+	//only certificates the handshake verified count, a server may send any other certificate
+	var certs []*x509.Certificate
+	for _, chain := range cs.VerifiedChains {
+		certs = append(certs, chain...)
+	}
+	if len(cs.VerifiedChains) == 0 && o.InsecureSkipVerifyForTesting && len(cs.PeerCertificates) > 0 {
+		//nothing was verified, the leaf is the only certificate the server proved to own
+		certs = cs.PeerCertificates[:1]
+	}
+	var got []string
+	for _, cert := range certs {
+		pin := SPKIPin(cert)
+		for _, want := range pins {
+			if pin == strings.TrimPrefix(want, "sha256/") {
+				return nil
+			}
+		}
+		got = append(got, pin)
+	}
+	err := &PinError{Host: cs.ServerName, Pins: pins, Got: got}
+	if o.PinReportOnly {
+		if o.PinReport != nil {
+			o.PinReport(err)
+		} else {
+			log.Println(err)
+		}
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+// netutil project pin_test.go
+package netutil
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+//pinGet fetches srv through a Client with o and returns the httpretcode and the request error.
+func pinGet(o *TLSOptions, httpurl string) (int, error) {
+	var err error
+	c := &Client{TLS: o, OnResponse: func(meta *ResponseMeta) { err = meta.Err }}
+	_, _, _, code, _ := c.UrlGet(httpurl, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	return code, err
+}
+
+func mustParseLeaf(t *testing.T, der []byte) *x509.Certificate {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestPins(t *testing.T) {
+	ca := newTestCA(t, "pin root")
+	cert, _, _ := ca.issue(t, "server", time.Now().Add(time.Hour), false, "svc.internal")
+	srv := newTLSTestServer(t, cert, nil)
+	leafpin := SPKIPin(mustParseLeaf(t, cert.Certificate[0]))
+	capin := SPKIPin(ca.cert)
+	other := newTestCA(t, "other")
+
+	for _, tc := range []struct {
+		name string
+		pins map[string][]string
+		ok   bool
+	}{
+		{"leaf", map[string][]string{"svc.internal": {leafpin}}, true},
+		{"root with prefix", map[string][]string{"svc.internal": {SPKIPin(other.cert), "sha256/" + capin}}, true},
+		{"wildcard", map[string][]string{"*.internal": {capin}}, true},
+		{"mixed case host", map[string][]string{"SVC.Internal": {SPKIPin(other.cert)}}, false},
+		{"mixed case wildcard", map[string][]string{"*.INTERNAL": {SPKIPin(other.cert)}}, false},
+		{"exact host wins over wildcard", map[string][]string{"svc.internal": {SPKIPin(other.cert)}, "*.internal": {capin}}, false},
+		{"mismatch", map[string][]string{"svc.internal": {SPKIPin(other.cert)}}, false},
+		{"other host", map[string][]string{"api.example": {SPKIPin(other.cert)}}, true},
+	} {
+		code, err := pinGet(&TLSOptions{RootCAPEM: [][]byte{ca.pem}, ServerName: "svc.internal", Pins: tc.pins}, srv.URL)
+		var pinerr *PinError
+		if tc.ok && code != 200 {
+			t.Errorf("%s: httpretcode %d %v", tc.name, code, err)
+		}
+		if !tc.ok && (code != 2 || !errors.As(err, &pinerr)) {
+			t.Errorf("%s: httpretcode %d %v, want 2 with a *PinError", tc.name, code, err)
+		}
+		if pinerr != nil && (pinerr.Host != "svc.internal" || len(pinerr.Got) != 2) {
+			t.Errorf("%s: PinError %+v, want the 2 verified certificates", tc.name, pinerr)
+		}
+	}
+}
+
+//TestPinsUnverifiedCertificate checks a certificate the server sends without it being part
+//of the verified chain cannot satisfy a pin.
+func TestPinsUnverifiedCertificate(t *testing.T) {
+	ca := newTestCA(t, "pin root")
+	pinned := newTestCA(t, "pinned")
+	cert, _, _ := ca.issue(t, "server", time.Now().Add(time.Hour), false, "svc.internal")
+	cert.Certificate = append(cert.Certificate, pinned.cert.Raw)
+	srv := newTLSTestServer(t, cert, nil)
+	pins := map[string][]string{"svc.internal": {SPKIPin(pinned.cert)}}
+
+	if code, err := pinGet(&TLSOptions{RootCAPEM: [][]byte{ca.pem}, ServerName: "svc.internal", Pins: pins}, srv.URL); code != 2 {
+		t.Errorf("pin of an extra certificate accepted: httpretcode %d %v", code, err)
+	}
+	//without verification only the leaf counts
+	if code, err := pinGet(&TLSOptions{InsecureSkipVerifyForTesting: true, ServerName: "svc.internal", Pins: pins}, srv.URL); code != 2 {
+		t.Errorf("insecure: pin of an extra certificate accepted: httpretcode %d %v", code, err)
+	}
+	leafpins := map[string][]string{"svc.internal": {SPKIPin(mustParseLeaf(t, cert.Certificate[0]))}}
+	if code, err := pinGet(&TLSOptions{InsecureSkipVerifyForTesting: true, ServerName: "svc.internal", Pins: leafpins}, srv.URL); code != 200 {
+		t.Errorf("insecure: leaf pin refused: httpretcode %d %v", code, err)
+	}
+}
+
+func TestPinReportOnly(t *testing.T) {
+	ca := newTestCA(t, "pin root")
+	cert, _, _ := ca.issue(t, "server", time.Now().Add(time.Hour), false, "svc.internal")
+	srv := newTLSTestServer(t, cert, nil)
+	pins := map[string][]string{"svc.internal": {SPKIPin(newTestCA(t, "other").cert)}}
+
+	var reports []*PinError
+	o := &TLSOptions{RootCAPEM: [][]byte{ca.pem}, ServerName: "svc.internal", Pins: pins, PinReportOnly: true, PinReport: func(err *PinError) {
+		reports = append(reports, err)
+	}}
+	if code, err := pinGet(o, srv.URL); code != 200 {
+		t.Fatalf("report only: httpretcode %d %v", code, err)
+	}
+	if len(reports) != 1 || reports[0].Host != "svc.internal" || len(reports[0].Pins) != 1 {
+		t.Errorf("reports %+v", reports)
+	}
+
+	//without PinReport the mismatch goes to the log package
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	o = &TLSOptions{RootCAPEM: [][]byte{ca.pem}, ServerName: "svc.internal", Pins: pins, PinReportOnly: true}
+	if code, err := pinGet(o, srv.URL); code != 200 {
+		t.Fatalf("report only: httpretcode %d %v", code, err)
+	}
+	if !strings.Contains(buf.String(), "public key pin mismatch for svc.internal") {
+		t.Errorf("log output %q", buf.String())
+	}
+}
@@ -0,0 +1,80 @@
+// netutil project progress.go
+package netutil
+
+import (
+	"io"
+	"time"
+)
+
+//Progress reports the transfer state of a request body (Upload) or a response body.
+//Total and ETA are -1 when the body length is unknown, rates are in bytes per second.
+type Progress struct {
+	Upload      bool
+	Transferred int64
+	Total       int64
+	Rate        float64
+	AvgRate     float64
+	ETA         time.Duration
+	Done        bool
+}
+
+//ProgressFunc is called from the goroutine reading the body, at most once per interval and once when done.
+type ProgressFunc func(p Progress)
+
+type progressReader struct {
+	rc       io.ReadCloser
+	fn       ProgressFunc
+	interval time.Duration
+	upload   bool
+	total    int64
+	n        int64
+	start    time.Time
+	last     time.Time
+	lastn    int64
+	done     bool
+}
+
+func newProgressReader(rc io.ReadCloser, total int64, upload bool, fn ProgressFunc, interval time.Duration) *progressReader {
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	now := time.Now()
+	return &progressReader{rc: rc, fn: fn, interval: interval, upload: upload, total: total, start: now, last: now}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.n += int64(n)
+	if err == io.EOF || (r.total >= 0 && r.n >= r.total) {
+		r.report(true)
+	} else if time.Since(r.last) >= r.interval {
+		r.report(false)
+	}
+	return n, err
+}
+
+func (r *progressReader) Close() error {
+	return r.rc.Close()
+}
+
+func (r *progressReader) report(done bool) {
+	if r.done {
+		return
+	}
+	r.done = done
+	now := time.Now()
+	p := Progress{Upload: r.upload, Transferred: r.n, Total: r.total, ETA: -1, Done: done}
+	if d := now.Sub(r.last).Seconds(); d > 0 {
+		p.Rate = float64(r.n-r.lastn) / d
+	}
+	if d := now.Sub(r.start).Seconds(); d > 0 {
+		p.AvgRate = float64(r.n) / d
+	}
+	if done {
+		p.ETA = 0
+	} else if r.total >= 0 && p.AvgRate > 0 {
+		p.ETA = time.Duration(float64(r.total-r.n) / p.AvgRate * float64(time.Second))
+	}
+	r.last, r.lastn = now, r.n
+	r.fn(p)
+}
@@ -0,0 +1,122 @@
+// netutil project progress_test.go
+package netutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type progressLog struct {
+	mu      sync.Mutex
+	reports []Progress
+}
+
+func (l *progressLog) add(p Progress) {
+	l.mu.Lock()
+	l.reports = append(l.reports, p)
+	l.mu.Unlock()
+}
+
+//of returns the reports of uploads or of downloads.
+func (l *progressLog) of(upload bool) []Progress {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var reports []Progress
+	for _, p := range l.reports {
+		if p.Upload == upload {
+			reports = append(reports, p)
+		}
+	}
+	return reports
+}
+
+//newSlowServer sends size bytes in 8 flushed chunks, with Content-Length unless chunked.
+func newSlowServer(t *testing.T, size int, chunked bool) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		if !chunked {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+		}
+		chunk := strings.Repeat("x", size/8)
+		for i := 0; i < 8; i++ {
+			w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+}
+
+//checkProgress checks reports grow to one final report of total bytes.
+func checkProgress(t *testing.T, reports []Progress, total int64, knowntotal bool) {
+	t.Helper()
+	if len(reports) < 2 {
+		t.Fatalf("got %d reports, want intermediate ones too", len(reports))
+	}
+	var last int64
+	for i, p := range reports {
+		if p.Transferred < last {
+			t.Errorf("report %d went back from %d to %d", i, last, p.Transferred)
+		}
+		last = p.Transferred
+		if p.Done != (i == len(reports)-1) {
+			t.Errorf("report %d Done %v", i, p.Done)
+		}
+		if !p.Done {
+			if knowntotal && (p.Total != total || p.ETA < 0) {
+				t.Errorf("report %d Total %d ETA %v", i, p.Total, p.ETA)
+			}
+			if !knowntotal && (p.Total != -1 || p.ETA != -1) {
+				t.Errorf("report %d of unknown length: Total %d ETA %v, want -1", i, p.Total, p.ETA)
+			}
+		}
+	}
+	final := reports[len(reports)-1]
+	if final.Transferred != total || final.ETA != 0 || final.AvgRate <= 0 {
+		t.Errorf("final report %+v, want %d bytes", final, total)
+	}
+}
+
+func TestProgressDownload(t *testing.T) {
+	srv := newSlowServer(t, 64<<10, false)
+	log := &progressLog{}
+	c := &Client{Progress: log.add, ProgressInterval: time.Millisecond}
+	content, _, _, code, _ := c.UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || len(content) != 64<<10 {
+		t.Fatalf("httpretcode %d, %d bytes", code, len(content))
+	}
+	checkProgress(t, log.of(false), 64<<10, true)
+}
+
+func TestProgressDownloadUnknownLength(t *testing.T) {
+	srv := newSlowServer(t, 64<<10, true)
+	log := &progressLog{}
+	c := &Client{Progress: log.add, ProgressInterval: time.Millisecond}
+	if _, _, _, code, _ := c.UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 200 {
+		t.Fatalf("httpretcode %d", code)
+	}
+	checkProgress(t, log.of(false), 64<<10, false)
+}
+
+func TestProgressUpload(t *testing.T) {
+	srv := newSlowServer(t, 8, false)
+	log := &progressLog{}
+	c := &Client{Progress: log.add, ProgressInterval: time.Hour}
+	m := NewMultipart()
+	m.AddBytes("f", "f.bin", make([]byte, 100<<10))
+	if _, _, _, code, _ := c.UrlPostMultipart(srv.URL, m, false, nil, nil, time.Second, 5*time.Second); code != 200 {
+		t.Fatalf("httpretcode %d", code)
+	}
+	reports := log.of(true)
+	//the interval allows only the final report
+	if len(reports) != 1 {
+		t.Fatalf("got %d upload reports, want 1", len(reports))
+	}
+	if p := reports[0]; !p.Done || p.Total <= 100<<10 || p.Transferred != p.Total {
+		t.Errorf("upload report %+v", p)
+	}
+}
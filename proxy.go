@@ -0,0 +1,473 @@
+// netutil project proxy.go
+package netutil
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//ProxyFunc returns the proxy url for a request, a nil url means no proxy.
+//the schemes http, https, socks5 (target resolved locally) and socks5h (target resolved
+//by the proxy) are supported, user and password of the url are used for authentication.
+type ProxyFunc func(*http.Request) (*url.URL, error)
+
+//NoProxy connects directly, ignoring HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+func NoProxy(*http.Request) (*url.URL, error) {
+	return nil, nil
+}
+
+//ParseProxy checks a proxy url, a url without scheme is taken as http.
+func ParseProxy(rawurl string) (*url.URL, error) {
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, errors.New("unsupported proxy scheme " + u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("proxy url without host: " + rawurl)
+	}
+	return u, nil
+}
+
+//ProxyURL returns a ProxyFunc which sends every request through rawurl.
+func ProxyURL(rawurl string) (ProxyFunc, error) {
+	u, err := ParseProxy(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return func(*http.Request) (*url.URL, error) {
+		return u, nil
+	}, nil
+}
+
+//proxyFor returns the proxy of c for request, the environment is used when c.ProxyPool and c.Proxy are nil.
+func (c *Client) proxyFor(request *http.Request) (*url.URL, error) {
+	if c.ProxyPool != nil {
+		return c.ProxyPool.Proxy(request)
+	}
+	if c.Proxy != nil {
+		return c.Proxy(request)
+	}
+	return environmentProxy(request)
+}
+
+//environmentProxy is http.ProxyFromEnvironment reading HTTP_PROXY, HTTPS_PROXY and NO_PROXY, or
+//their lower case forms, at every request instead of once, so changes of the environment apply.
+func environmentProxy(request *http.Request) (*url.URL, error) {
+	var rawurl string
+	switch request.URL.Scheme {
+	case "https":
+		rawurl = getenv("HTTPS_PROXY", "https_proxy")
+	case "http":
+		//the client of a CGI program sets HTTP_PROXY with a Proxy header
+		if os.Getenv("REQUEST_METHOD") != "" {
+			rawurl = os.Getenv("http_proxy")
+		} else {
+			rawurl = getenv("HTTP_PROXY", "http_proxy")
+		}
+	}
+	if rawurl == "" || !useEnvironmentProxy(request.URL, getenv("NO_PROXY", "no_proxy")) {
+		return nil, nil
+	}
+	proxy, err := ParseProxy(rawurl)
+	if err != nil {
+		return nil, errors.New("invalid proxy address " + rawurl + ": " + err.Error())
+	}
+	return proxy, nil
+}
+
+//getenv returns the first variable of names which is not empty.
+func getenv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+//useEnvironmentProxy reports whether target is not excluded by noproxy, a list of hosts and
+//domains with their subdomains, IP addresses and CIDR ranges, with an optional port, or *.
+//localhost and loopback addresses are never proxied.
+func useEnvironmentProxy(target *url.URL, noproxy string) bool {
+	host, port := strings.ToLower(target.Hostname()), target.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[target.Scheme]
+	}
+	ip := net.ParseIP(host)
+	if host == "localhost" || ip != nil && ip.IsLoopback() {
+		return false
+	}
+	for _, entry := range strings.FieldsFunc(strings.ToLower(noproxy), func(r rune) bool { return r == ',' || r == ' ' }) {
+		if entry == "*" {
+			return false
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return false
+			}
+			continue
+		}
+		entryhost, entryport := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryhost, entryport = h, p
+		}
+		if entryport != "" && entryport != port {
+			continue
+		}
+		if entryip := net.ParseIP(entryhost); entryip != nil {
+			if entryip.Equal(ip) {
+				return false
+			}
+			continue
+		}
+		entryhost = strings.TrimPrefix(strings.TrimPrefix(entryhost, "*"), ".")
+		if host == entryhost || strings.HasSuffix(host, "."+entryhost) {
+			return false
+		}
+	}
+	return true
+}
+
+//requestProxy holds the proxy chosen for one request and whether the proxy itself failed, it travels
+//in the request context from proxyTransport to the dial and back to Client.do.
+type requestProxy struct {
+	mu     sync.Mutex
+	proxy  *url.URL
+	failed bool
+}
+
+type requestProxyKey struct{}
+
+//withRequestProxy returns ctx carrying a new holder for the proxy of a request.
+func withRequestProxy(ctx context.Context) (context.Context, *requestProxy) {
+	rp := &requestProxy{}
+	return context.WithValue(ctx, requestProxyKey{}, rp), rp
+}
+
+//requestProxyFrom returns the holder of ctx, nil when it has none.
+func requestProxyFrom(ctx context.Context) *requestProxy {
+	rp, _ := ctx.Value(requestProxyKey{}).(*requestProxy)
+	return rp
+}
+
+func (rp *requestProxy) set(proxy *url.URL) {
+	if rp == nil {
+		return
+	}
+	rp.mu.Lock()
+	rp.proxy = proxy
+	rp.mu.Unlock()
+}
+
+//get returns the proxy of the last request sent with the holder, nil for a direct connection.
+func (rp *requestProxy) get() *url.URL {
+	if rp == nil {
+		return nil
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.proxy
+}
+
+//fail records that the proxy could not be reached or refused the connection.
+func (rp *requestProxy) fail() {
+	if rp == nil {
+		return
+	}
+	rp.mu.Lock()
+	rp.failed = true
+	rp.mu.Unlock()
+}
+
+//proxyFailed reports whether the proxy itself failed, an error of the target or a canceled request does not count.
+func (rp *requestProxy) proxyFailed() bool {
+	if rp == nil {
+		return false
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.failed
+}
+
+//copy takes the proxy and outcome of the holder of a request sent on behalf of rp's.
+func (rp *requestProxy) copy(from *requestProxy) {
+	if rp == nil || from == nil {
+		return
+	}
+	from.mu.Lock()
+	proxy, failed := from.proxy, from.failed
+	from.mu.Unlock()
+	rp.mu.Lock()
+	rp.proxy, rp.failed = proxy, failed
+	rp.mu.Unlock()
+}
+
+func isSocksProxy(proxy *url.URL) bool {
+	return proxy != nil && (proxy.Scheme == "socks5" || proxy.Scheme == "socks5h")
+}
+
+//proxyTransport sends each request with the http.Transport of its proxy, made by newTransport on first
+//use, and records the proxy in the holder of the request context. http.Transport pools connections by
+//the proxy its Proxy function returned, which is nil for the socks proxies dialed by dialSocks5, so
+//one Transport per proxy keeps the connections of different proxies apart.
+type proxyTransport struct {
+	c            *Client
+	newTransport func(proxy *url.URL) *http.Transport
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func (t *proxyTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	proxy, err := t.c.proxyFor(request)
+	if err == nil && isSocksProxy(proxy) {
+		//the transport dials the punycode form of such a host
+		for _, ch := range request.URL.Hostname() {
+			if ch >= 0x80 {
+				err = errors.New("socks5: non-ASCII host name " + request.URL.Hostname())
+				break
+			}
+		}
+	}
+	if err != nil {
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return nil, err
+	}
+	requestProxyFrom(request.Context()).set(proxy)
+	key := ""
+	if proxy != nil {
+		key = proxy.String()
+	}
+	t.mu.Lock()
+	if t.transports == nil {
+		t.transports = map[string]*http.Transport{}
+	}
+	transport := t.transports[key]
+	if transport == nil {
+		transport = t.newTransport(proxy)
+		t.transports[key] = transport
+	}
+	t.mu.Unlock()
+	return transport.RoundTrip(request)
+}
+
+//CloseIdleConnections closes the idle connections of every proxy.
+func (t *proxyTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, transport := range t.transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+type dialFunc func(ctx context.Context, netw, addr string) (net.Conn, error)
+
+//useProxy makes transport, dialing with dial, send every request through proxy. Socks proxies are
+//dialed by dialSocks5, http.Transport only dials socks5h itself and always lets the proxy resolve
+//the name. Failing to connect to the proxy, its TLS or socks handshake and a CONNECT answered
+//407 are recorded as failures of the proxy in the holder of the request context.
+func useProxy(transport *http.Transport, proxy *url.URL, dial dialFunc) {
+	if isSocksProxy(proxy) {
+		transport.DialContext = func(ctx context.Context, netw, addr string) (net.Conn, error) {
+			return dialSocks5(ctx, dial, proxy, netw, addr)
+		}
+		return
+	}
+	transport.Proxy = http.ProxyURL(proxy)
+	//the transport dials nothing but the proxy
+	transport.DialContext = func(ctx context.Context, netw, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, netw, addr)
+		if err != nil {
+			requestProxyFrom(ctx).fail()
+		}
+		return conn, err
+	}
+	if proxy.Scheme == "https" {
+		//like the transport does it, with the handshake failures recorded
+		transport.DialTLSContext = func(ctx context.Context, netw, addr string) (net.Conn, error) {
+			conn, err := transport.DialContext(ctx, netw, addr)
+			if err != nil {
+				return nil, err
+			}
+			config := &tls.Config{}
+			if transport.TLSClientConfig != nil {
+				config = transport.TLSClientConfig.Clone()
+			}
+			if config.ServerName == "" {
+				config.ServerName = proxy.Hostname()
+			}
+			if transport.TLSHandshakeTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, transport.TLSHandshakeTimeout)
+				defer cancel()
+			}
+			tlsconn := tls.Client(conn, config)
+			if err = tlsconn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				requestProxyFrom(ctx).fail()
+				return nil, err
+			}
+			return tlsconn, nil
+		}
+	}
+	transport.OnProxyConnectResponse = func(ctx context.Context, proxyurl *url.URL, connectreq *http.Request, connectres *http.Response) error {
+		if connectres.StatusCode == http.StatusProxyAuthRequired {
+			requestProxyFrom(ctx).fail()
+		}
+		return nil
+	}
+}
+
+//socksReplyError is a CONNECT refused by a socks proxy with reply code 1 to 8.
+type socksReplyError byte
+
+func (e socksReplyError) Error() string {
+	return fmt.Sprintf("connect failed, reply code %d", byte(e))
+}
+
+//dialSocks5 connects to addr through the socks5 proxy with the RFC 1928 CONNECT command
+//and RFC 1929 username/password authentication. Failures of the proxy are recorded in the
+//holder of ctx, not those of the target: its name resolution or a reply it is unreachable.
+func dialSocks5(ctx context.Context, dial dialFunc, proxy *url.URL, netw, addr string) (net.Conn, error) {
+	host, portstr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portstr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("socks5: bad port " + portstr)
+	}
+	proxyaddr := proxy.Host
+	if proxy.Port() == "" {
+		proxyaddr = net.JoinHostPort(proxy.Hostname(), "1080")
+	}
+
+	//socks5 resolves the target locally, socks5h sends the name to the proxy
+	req := []byte{5, 1, 0}
+	ip := net.ParseIP(host)
+	if ip == nil && proxy.Scheme == "socks5" {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, errors.New("socks5: no address for " + host)
+		}
+		ip = ips[0].IP
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else if ip != nil {
+		req = append(append(req, 4), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return nil, errors.New("socks5: host name too long")
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+
+	conn, err := dial(ctx, "tcp", proxyaddr)
+	if err != nil {
+		requestProxyFrom(ctx).fail()
+		return nil, err
+	}
+	if err = socks5Handshake(conn, proxy.User, req); err != nil {
+		conn.Close()
+		//network or host unreachable, connection refused and TTL expired are about the target
+		if code, ok := err.(socksReplyError); !ok || code < 3 || code > 6 {
+			requestProxyFrom(ctx).fail()
+		}
+		return nil, fmt.Errorf("socks5 proxy %s: %v", proxyaddr, err)
+	}
+	return conn, nil
+}
+
+func socks5Handshake(conn net.Conn, user *url.Userinfo, connectreq []byte) error {
+	greeting := []byte{5, 1, 0}
+	if user != nil {
+		greeting = []byte{5, 2, 0, 2}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != 5 {
+		return errors.New("bad version")
+	}
+	switch buf[1] {
+	case 0:
+	case 2:
+		if user == nil {
+			return errors.New("authentication required")
+		}
+		name := user.Username()
+		password, _ := user.Password()
+		if len(name) > 255 || len(password) > 255 {
+			return errors.New("user name or password too long")
+		}
+		auth := append([]byte{1, byte(len(name))}, name...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return err
+		}
+		if buf[1] != 0 {
+			return errors.New("authentication failed")
+		}
+	default:
+		return errors.New("no acceptable authentication method")
+	}
+
+	if _, err := conn.Write(connectreq); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return err
+	}
+	if buf[1] != 0 {
+		return socksReplyError(buf[1])
+	}
+	//skip the bound address and port
+	var skip int
+	switch buf[3] {
+	case 1:
+		skip = 4 + 2
+	case 4:
+		skip = 16 + 2
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		skip = int(buf[0]) + 2
+	default:
+		return errors.New("bad address type in reply")
+	}
+	_, err := io.ReadFull(conn, buf[:skip])
+	return err
+}
@@ -0,0 +1,397 @@
+// netutil project proxy_test.go
+package netutil
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+//the hosts of the proxy tests end with .test, they do not resolve, so only a proxy reaches them
+
+//newBackend answers with the Host it was asked for.
+func newBackend(t *testing.T, secure bool) *testServer {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "backend "+r.Host)
+	}
+	if secure {
+		return newTestTLSServer(t, h, nil)
+	}
+	return newTestServer(t, h)
+}
+
+func backendPort(srv *testServer) string {
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	return port
+}
+
+//proxyStandIn is an HTTP proxy with basic authentication, it forwards absolute-form
+//requests to plain and tunnels CONNECT to secure, whatever host was asked for.
+type proxyStandIn struct {
+	*testServer
+	addr string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newProxyStandIn(t *testing.T, user, password string, plain, secure *testServer) *proxyStandIn {
+	p := &proxyStandIn{}
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	p.testServer = newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != auth {
+			p.record(r.Method + " unauthorized")
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method == "CONNECT" {
+			p.record("CONNECT " + r.Host)
+			backend, err := net.Dial("tcp", secure.Listener.Addr().String())
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				backend.Close()
+				return
+			}
+			go func() {
+				io.Copy(backend, conn)
+				backend.Close()
+			}()
+			io.Copy(conn, backend)
+			conn.Close()
+			return
+		}
+		p.record(r.Method + " " + r.URL.String())
+		req, _ := http.NewRequest(r.Method, plain.URL+r.URL.RequestURI(), r.Body)
+		req.Host = r.Host
+		resp, err := plain.Client().Do(req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	})
+	p.addr = p.Listener.Addr().String()
+	return p
+}
+
+func (p *proxyStandIn) record(request string) {
+	p.mu.Lock()
+	p.requests = append(p.requests, request)
+	p.mu.Unlock()
+}
+
+//take returns and forgets the requests seen so far.
+func (p *proxyStandIn) take() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	requests := p.requests
+	p.requests = nil
+	return requests
+}
+
+//socksStandIn is a socks5 server connecting every CONNECT to backend,
+//it requires username/password authentication when user is not empty.
+type socksStandIn struct {
+	ln   net.Listener
+	addr string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newSocksStandIn(t *testing.T, user, password string, backend *testServer) *socksStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socksStandIn{ln: ln, addr: ln.Addr().String()}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, user, password, backend.Listener.Addr().String())
+		}
+	}()
+	return s
+}
+
+func (s *socksStandIn) serve(conn net.Conn, user, password, backend string) {
+	defer conn.Close()
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	methods := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	if user == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		offered := false
+		for _, m := range methods {
+			offered = offered || m == 2
+		}
+		if !offered {
+			conn.Write([]byte{5, 0xff})
+			return
+		}
+		conn.Write([]byte{5, 2})
+		io.ReadFull(conn, buf[:2])
+		name := make([]byte, buf[1])
+		io.ReadFull(conn, name)
+		io.ReadFull(conn, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(conn, pass)
+		if string(name) != user || string(pass) != password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 4:
+		io.ReadFull(conn, buf[:16])
+		host = net.IP(buf[:16]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		n := int(buf[0])
+		io.ReadFull(conn, buf[:n])
+		host = string(buf[:n])
+	}
+	io.ReadFull(conn, buf[:2])
+	s.mu.Lock()
+	s.targets = append(s.targets, net.JoinHostPort(host, fmt.Sprint(int(buf[0])<<8|int(buf[1]))))
+	s.mu.Unlock()
+	target, err := net.Dial("tcp", backend)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func (s *socksStandIn) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	targets := s.targets
+	s.targets = nil
+	return targets
+}
+
+func proxyClient(t *testing.T, rawurl string) *Client {
+	proxy, err := ProxyURL(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{Proxy: proxy}
+}
+
+func TestSocks5Proxy(t *testing.T) {
+	backend := newBackend(t, false)
+	port := backendPort(backend)
+	s := newSocksStandIn(t, "", "", backend)
+
+	//socks5h lets the proxy resolve the name
+	content, _, _, code, _ := proxyClient(t, "socks5h://"+s.addr).UrlGet("http://target.test:"+port+"/", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "backend target.test:"+port {
+		t.Fatalf("socks5h: %d %q", code, content)
+	}
+	if targets := s.take(); len(targets) != 1 || targets[0] != "target.test:"+port {
+		t.Errorf("socks5h CONNECT targets %v", targets)
+	}
+
+	//socks5 resolves the name locally and sends the address
+	content, _, _, code, _ = proxyClient(t, "socks5://"+s.addr).UrlGet("http://localhost:"+port+"/", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "backend localhost:"+port {
+		t.Fatalf("socks5: %d %q", code, content)
+	}
+	targets := s.take()
+	if len(targets) != 1 {
+		t.Fatalf("socks5 CONNECT targets %v", targets)
+	}
+	if host, _, _ := net.SplitHostPort(targets[0]); net.ParseIP(host) == nil {
+		t.Errorf("socks5 sent the name %q instead of an address", targets[0])
+	}
+
+	//a name socks5 cannot resolve locally fails before the proxy is asked
+	if _, _, _, code, _ = proxyClient(t, "socks5://"+s.addr).UrlGet("http://target.test:"+port+"/", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 2 {
+		t.Errorf("socks5 with an unresolvable name: httpretcode %d, want 2", code)
+	}
+	if targets := s.take(); len(targets) != 0 {
+		t.Errorf("socks5 asked the proxy for %v", targets)
+	}
+}
+
+func TestSocks5ProxyAuth(t *testing.T) {
+	backend := newBackend(t, false)
+	port := backendPort(backend)
+	s := newSocksStandIn(t, "alice", "secret", backend)
+
+	content, _, _, code, _ := proxyClient(t, "socks5h://alice:secret@"+s.addr).UrlGet("http://target.test:"+port+"/", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "backend target.test:"+port {
+		t.Fatalf("good credentials: %d %q", code, content)
+	}
+	for _, rawurl := range []string{"socks5h://alice:wrong@" + s.addr, "socks5h://" + s.addr} {
+		if _, _, _, code, _ = proxyClient(t, rawurl).UrlGet("http://target.test:"+port+"/", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 2 {
+			t.Errorf("%s: httpretcode %d, want 2", rawurl, code)
+		}
+	}
+	if targets := s.take(); len(targets) != 1 {
+		t.Errorf("CONNECT targets %v, want only the authenticated one", targets)
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	backend := newBackend(t, false)
+	secure := newBackend(t, true)
+	p := newProxyStandIn(t, "u", "p", backend, secure)
+
+	var metas []*ResponseMeta
+	c := proxyClient(t, "http://u:p@"+p.addr)
+	c.TLS = &TLSOptions{InsecureSkipVerifyForTesting: true}
+	c.OnResponse = func(meta *ResponseMeta) { metas = append(metas, meta) }
+
+	//plain http is forwarded, https tunneled with CONNECT
+	content, _, _, code, _ := c.UrlGet("http://target.test:8080/a", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "backend target.test:8080" {
+		t.Fatalf("http through the proxy: %d %q", code, content)
+	}
+	content, _, _, code, _ = c.UrlGet("https://target.test:8443/b", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "backend target.test:8443" {
+		t.Fatalf("https through the proxy: %d %q", code, content)
+	}
+	requests := p.take()
+	if len(requests) != 2 || requests[0] != "GET http://target.test:8080/a" || requests[1] != "CONNECT target.test:8443" {
+		t.Errorf("proxy requests %q", requests)
+	}
+	if len(metas) != 2 || metas[0].Proxy == nil || metas[0].Proxy.Host != p.addr || metas[1].Proxy == nil {
+		t.Errorf("ResponseMeta.Proxy not reported: %+v", metas)
+	}
+
+	bad := proxyClient(t, "http://u:wrong@"+p.addr)
+	bad.TLS = c.TLS
+	if _, _, _, code, _ = bad.UrlGet("http://target.test:8080/a", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != http.StatusProxyAuthRequired {
+		t.Errorf("http with bad credentials: httpretcode %d, want 407", code)
+	}
+	if _, _, _, code, _ = bad.UrlGet("https://target.test:8443/b", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 2 {
+		t.Errorf("CONNECT with bad credentials: httpretcode %d, want 2", code)
+	}
+}
+
+func TestProxyEnvironment(t *testing.T) {
+	backend := newBackend(t, false)
+	secure := newBackend(t, true)
+	p := newProxyStandIn(t, "env", "secret", backend, secure)
+	t.Setenv("HTTP_PROXY", "http://env:secret@"+p.addr)
+	t.Setenv("HTTPS_PROXY", "http://env:secret@"+p.addr)
+	t.Setenv("NO_PROXY", "direct.test")
+
+	port := backendPort(backend)
+	content, _, _, code, _ := UrlGet("http://target.test:"+port+"/env", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "backend target.test:"+port {
+		t.Fatalf("HTTP_PROXY: %d %q", code, content)
+	}
+	c := &Client{TLS: &TLSOptions{InsecureSkipVerifyForTesting: true}}
+	content, _, _, code, _ = c.UrlGet("https://target.test:"+backendPort(secure)+"/env", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 {
+		t.Fatalf("HTTPS_PROXY: %d %q", code, content)
+	}
+	//NO_PROXY hosts are dialed directly, the name does not resolve
+	if _, _, _, code, _ = UrlGet("http://direct.test:"+port+"/env", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 2 {
+		t.Errorf("NO_PROXY: httpretcode %d, want 2", code)
+	}
+	//NoProxy ignores the environment
+	if _, _, _, code, _ = (&Client{Proxy: NoProxy}).UrlGet("http://target.test:"+port+"/env", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 2 {
+		t.Errorf("NoProxy: httpretcode %d, want 2", code)
+	}
+	//the environment is read at every request
+	t.Setenv("HTTP_PROXY", "")
+	if _, _, _, code, _ = UrlGet("http://target.test:"+port+"/env", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 2 {
+		t.Errorf("HTTP_PROXY removed: httpretcode %d, want 2", code)
+	}
+	requests := p.take()
+	want := []string{"GET http://target.test:" + port + "/env", "CONNECT target.test:" + backendPort(secure)}
+	if len(requests) != len(want) || requests[0] != want[0] || requests[1] != want[1] {
+		t.Errorf("proxy requests %q, want %q", requests, want)
+	}
+}
+
+func TestProxyEnvironmentNoProxy(t *testing.T) {
+	for _, tc := range []struct {
+		target, noproxy string
+		proxied         bool
+	}{
+		{"http://a.test/", "", true},
+		{"http://a.test/", "*", false},
+		{"http://A.Test/", "a.test", false},
+		{"http://sub.a.test/", "a.test", false},
+		{"http://sub.a.test/", ".a.test", false},
+		{"http://sub.a.test/", "*.a.test", false},
+		{"http://ba.test/", "a.test", true},
+		{"http://a.test:8080/", "b.test, a.test:8080", false},
+		{"http://a.test/", "a.test:8080", true},
+		{"https://a.test/", "a.test:443", false},
+		{"http://10.1.2.3/", "10.0.0.0/8", false},
+		{"http://11.1.2.3/", "10.0.0.0/8", true},
+		{"http://[2001:db8::1]/", "2001:db8::1", false},
+		{"http://localhost:8080/", "", false},
+		{"http://127.0.0.1/", "", false},
+		{"http://[::1]/", "", false},
+	} {
+		u, _ := url.Parse(tc.target)
+		if got := useEnvironmentProxy(u, tc.noproxy); got != tc.proxied {
+			t.Errorf("%s with NO_PROXY %q: proxied %v", tc.target, tc.noproxy, got)
+		}
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	u, err := ParseProxy("proxy.example:3128")
+	if err != nil || u.Scheme != "http" || u.Host != "proxy.example:3128" {
+		t.Errorf("ParseProxy without scheme: %v %v", u, err)
+	}
+	u, err = ParseProxy("socks5h://a:b@proxy.example:1080")
+	if err != nil || u.User.Username() != "a" {
+		t.Errorf("ParseProxy socks5h: %v %v", u, err)
+	}
+	for _, rawurl := range []string{"ftp://proxy.example", "http://", "http://%zz"} {
+		if _, err := ParseProxy(rawurl); err == nil {
+			t.Errorf("ParseProxy(%q) accepted", rawurl)
+		}
+	}
+	proxy, err := ProxyURL("socks5://proxy.example:1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://x.example/", nil)
+	if u, _ := proxy(req); u == nil || u.String() != "socks5://proxy.example:1080" {
+		t.Errorf("ProxyURL returned %v", u)
+	}
+}
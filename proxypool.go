@@ -0,0 +1,251 @@
+// netutil project proxypool.go
+package netutil
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+//proxy selection strategies of ProxyPool
+const (
+	ProxyRoundRobin = iota
+	ProxyRandom
+	//ProxyLeastRecentlyFailed picks the healthy proxy whose last failure is the oldest
+	ProxyLeastRecentlyFailed
+)
+
+//ProxyPool rotates requests over several proxies, set it as Client.ProxyPool. A proxy is unhealthy
+//after MaxFailures consecutive failures and is skipped until a background probe succeeds; when all
+//proxies are unhealthy the least recently failed one is used. A failure is a connection to the proxy
+//or its TLS or socks handshake failing or a 407 answer, errors of the target and canceled requests
+//do not count.
+type ProxyPool struct {
+	Strategy int
+	//MaxFailures is the number of consecutive failures making a proxy unhealthy, 0 means 3.
+	MaxFailures int
+	//ProbeURL is fetched through an unhealthy proxy to probe it, empty means only connecting to the proxy.
+	ProbeURL string
+	//ProbeInterval is the time between probes of unhealthy proxies, 0 means 30s.
+	ProbeInterval time.Duration
+	//ProbeTimeout limits one probe, 0 means 10s.
+	ProbeTimeout time.Duration
+
+	mu      sync.Mutex
+	proxies []*poolProxy
+	next    int
+	probing bool
+	closed  chan struct{}
+}
+
+type poolProxy struct {
+	url         *url.URL
+	healthy     bool
+	failures    int
+	lastfailure time.Time
+	served      int64
+	failed      int64
+}
+
+//ProxyStat is the state of one proxy of a ProxyPool.
+type ProxyStat struct {
+	URL                 *url.URL
+	Healthy             bool
+	ConsecutiveFailures int
+	LastFailure         time.Time
+	Served              int64
+	Failed              int64
+}
+
+func NewProxyPool(strategy int, rawurls ...string) (*ProxyPool, error) {
+	if len(rawurls) == 0 {
+		return nil, errors.New("proxy pool without proxy")
+	}
+	p := &ProxyPool{Strategy: strategy, closed: make(chan struct{})}
+	for _, rawurl := range rawurls {
+		u, err := ParseProxy(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		p.proxies = append(p.proxies, &poolProxy{url: u, healthy: true})
+	}
+	return p, nil
+}
+
+//Proxy selects the proxy for a request, it is a ProxyFunc.
+func (p *ProxyPool) Proxy(*http.Request) (*url.URL, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var candidates []*poolProxy
+	for _, pp := range p.proxies {
+		if pp.healthy {
+			candidates = append(candidates, pp)
+		}
+	}
+	if len(candidates) == 0 {
+		return p.leastRecentlyFailed(p.proxies).url, nil
+	}
+	switch p.Strategy {
+	case ProxyRandom:
+		return candidates[rand.Intn(len(candidates))].url, nil
+	case ProxyLeastRecentlyFailed:
+		return p.leastRecentlyFailed(candidates).url, nil
+	}
+	//round robin over all proxies, skipping the unhealthy ones
+	for i := 0; i < len(p.proxies); i++ {
+		pp := p.proxies[(p.next+i)%len(p.proxies)]
+		if pp.healthy {
+			p.next = (p.next + i + 1) % len(p.proxies)
+			return pp.url, nil
+		}
+	}
+	return candidates[0].url, nil
+}
+
+func (p *ProxyPool) leastRecentlyFailed(proxies []*poolProxy) *poolProxy {
+	best := proxies[0]
+	for _, pp := range proxies[1:] {
+		if pp.lastfailure.Before(best.lastfailure) {
+			best = pp
+		}
+	}
+	return best
+}
+
+func (p *ProxyPool) find(u *url.URL) *poolProxy {
+	for _, pp := range p.proxies {
+		if pp.url == u || pp.url.String() == u.String() {
+			return pp
+		}
+	}
+	return nil
+}
+
+//report records the outcome of a request served by proxy u.
+func (p *ProxyPool) report(u *url.URL, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pp := p.find(u)
+	if pp == nil {
+		return
+	}
+	if ok {
+		pp.served++
+		pp.failures = 0
+		pp.healthy = true
+		return
+	}
+	pp.failed++
+	pp.failures++
+	pp.lastfailure = time.Now()
+	maxfailures := p.MaxFailures
+	if maxfailures <= 0 {
+		maxfailures = 3
+	}
+	if pp.failures >= maxfailures && pp.healthy {
+		pp.healthy = false
+		if !p.probing {
+			p.probing = true
+			go p.probeLoop()
+		}
+	}
+}
+
+//MarkFailure counts a failure of the proxy rawurl noticed by the caller, e.g. a ban page.
+func (p *ProxyPool) MarkFailure(rawurl string) {
+	if u, err := ParseProxy(rawurl); err == nil {
+		p.report(u, false)
+	}
+}
+
+//Stats returns the state of every proxy.
+func (p *ProxyPool) Stats() []ProxyStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]ProxyStat, 0, len(p.proxies))
+	for _, pp := range p.proxies {
+		stats = append(stats, ProxyStat{URL: pp.url, Healthy: pp.healthy, ConsecutiveFailures: pp.failures, LastFailure: pp.lastfailure, Served: pp.served, Failed: pp.failed})
+	}
+	return stats
+}
+
+//Close stops the background probing.
+func (p *ProxyPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.closed:
+	default:
+		close(p.closed)
+	}
+}
+
+func (p *ProxyPool) probeLoop() {
+	interval := p.ProbeInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		var unhealthy []*url.URL
+		for _, pp := range p.proxies {
+			if !pp.healthy {
+				unhealthy = append(unhealthy, pp.url)
+			}
+		}
+		if len(unhealthy) == 0 {
+			p.probing = false
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+		for _, u := range unhealthy {
+			if p.probe(u) {
+				p.report(u, true)
+			}
+		}
+	}
+}
+
+//probe checks an unhealthy proxy by fetching ProbeURL through it or by connecting to it.
+func (p *ProxyPool) probe(u *url.URL) bool {
+	timeout := p.ProbeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if p.ProbeURL == "" {
+		addr := u.Host
+		if u.Port() == "" {
+			port := "80"
+			switch u.Scheme {
+			case "https":
+				port = "443"
+			case "socks5", "socks5h":
+				port = "1080"
+			}
+			addr = net.JoinHostPort(u.Hostname(), port)
+		}
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	c := &Client{Proxy: func(*http.Request) (*url.URL, error) {
+		return u, nil
+	}}
+	_, _, _, code, _ := c.UrlGet(p.ProbeURL, nil, true, nil, nil, timeout, timeout, nil)
+	return code >= 200 && code < 400
+}
@@ -0,0 +1,244 @@
+// netutil project proxypool_test.go
+package netutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+//newNamedBackend answers name after delay.
+func newNamedBackend(t *testing.T, name string, delay time.Duration) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		fmt.Fprint(w, name)
+	})
+}
+
+func newPoolProxy(t *testing.T, backend *testServer) *proxyStandIn {
+	return newProxyStandIn(t, "u", "p", backend, backend)
+}
+
+func poolStat(pool *ProxyPool, addr string) ProxyStat {
+	for _, s := range pool.Stats() {
+		if s.URL.Host == addr {
+			return s
+		}
+	}
+	return ProxyStat{}
+}
+
+func TestProxyPoolRoundRobin(t *testing.T) {
+	p1 := newPoolProxy(t, newNamedBackend(t, "one", 0))
+	p2 := newPoolProxy(t, newNamedBackend(t, "two", 0))
+	pool, err := NewProxyPool(ProxyRoundRobin, "http://u:p@"+p1.addr, "u:p@"+p2.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	c := &Client{ProxyPool: pool}
+	var got []string
+	for i := 0; i < 4; i++ {
+		content, _, _, code, _ := c.UrlGet("http://pool.test/", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+		if code != 200 {
+			t.Fatalf("request %d: httpretcode %d", i, code)
+		}
+		got = append(got, string(content))
+	}
+	if fmt.Sprint(got) != "[one two one two]" {
+		t.Errorf("round robin served %v", got)
+	}
+	if s := poolStat(pool, p1.addr); s.Served != 2 || !s.Healthy {
+		t.Errorf("stats of the first proxy %+v", s)
+	}
+	if _, err := NewProxyPool(ProxyRoundRobin); err == nil {
+		t.Error("NewProxyPool accepted no proxy")
+	}
+	if _, err := NewProxyPool(ProxyRoundRobin, "ftp://x"); err == nil {
+		t.Error("NewProxyPool accepted an ftp proxy")
+	}
+}
+
+func TestProxyPoolHealth(t *testing.T) {
+	good := newPoolProxy(t, newNamedBackend(t, "good", 0))
+	//a proxy address nobody listens on until it comes back
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadaddr := ln.Addr().String()
+	ln.Close()
+
+	pool, err := NewProxyPool(ProxyRoundRobin, "http://u:p@"+deadaddr, "http://u:p@"+good.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.MaxFailures = 2
+	pool.ProbeInterval = 20 * time.Millisecond
+	c := &Client{ProxyPool: pool}
+	codes := map[int]int{}
+	for i := 0; i < 8; i++ {
+		_, _, _, code, _ := c.UrlGet("http://pool.test/", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+		codes[code]++
+	}
+	//the dead proxy failed twice, then only the good one was used
+	if codes[2] != 2 || codes[200] != 6 {
+		t.Errorf("httpretcodes %v, want 2 failures", codes)
+	}
+	if s := poolStat(pool, deadaddr); s.Healthy || s.Failed != 2 || s.ConsecutiveFailures != 2 || s.LastFailure.IsZero() {
+		t.Errorf("stats of the dead proxy %+v", s)
+	}
+
+	//the background probe finds the proxy again once it listens
+	ln, err = net.Listen("tcp", deadaddr)
+	if err != nil {
+		t.Skip("cannot listen again on", deadaddr, err)
+	}
+	revived := newUnstartedTestServer(t, good.Config.Handler.ServeHTTP)
+	revived.Listener.Close()
+	revived.Listener = ln
+	revived.Start()
+	deadline := time.Now().Add(2 * time.Second)
+	for !poolStat(pool, deadaddr).Healthy {
+		if time.Now().After(deadline) {
+			t.Fatal("the probe did not mark the proxy healthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyPoolAllUnhealthy(t *testing.T) {
+	pool, err := NewProxyPool(ProxyLeastRecentlyFailed, "http://a.test:1", "http://b.test:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.MaxFailures = 1
+	pool.ProbeInterval = time.Hour
+	pool.MarkFailure("http://a.test:1")
+	time.Sleep(time.Millisecond)
+	pool.MarkFailure("http://b.test:1")
+	for _, s := range pool.Stats() {
+		if s.Healthy {
+			t.Fatalf("%v still healthy", s.URL)
+		}
+	}
+	//the least recently failed proxy is used when none is healthy
+	if u, _ := pool.Proxy(nil); u.Host != "a.test:1" {
+		t.Errorf("chose %v, want a.test:1", u)
+	}
+}
+
+//TestProxyPoolHedgedProxy checks the proxy reported is the one of the response which won,
+//not the one chosen last by another copy of the request.
+func TestProxyPoolHedgedProxy(t *testing.T) {
+	fast := newPoolProxy(t, newNamedBackend(t, "fast", 100*time.Millisecond))
+	slow := newPoolProxy(t, newNamedBackend(t, "slow", 400*time.Millisecond))
+	pool, err := NewProxyPool(ProxyRoundRobin, "http://u:p@"+fast.addr, "http://u:p@"+slow.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	var mu sync.Mutex
+	var metas []*ResponseMeta
+	c := &Client{ProxyPool: pool, Hedge: &HedgePolicy{Delay: 20 * time.Millisecond}, OnResponse: func(meta *ResponseMeta) {
+		mu.Lock()
+		metas = append(metas, meta)
+		mu.Unlock()
+	}}
+	content, _, _, code, _ := c.UrlGet("http://pool.test/", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || string(content) != "fast" {
+		t.Fatalf("httpretcode %d content %q", code, content)
+	}
+	if len(metas) != 1 {
+		t.Fatalf("%d OnResponse calls, want 1", len(metas))
+	}
+	if metas[0].Proxy == nil || metas[0].Proxy.Host != fast.addr {
+		t.Fatalf("ResponseMeta.Proxy %v, want the proxy of the fast copy %s", metas[0].Proxy, fast.addr)
+	}
+	if s := poolStat(pool, fast.addr); s.Served != 1 {
+		t.Errorf("the winning proxy was not credited: %+v", s)
+	}
+	if s := poolStat(pool, slow.addr); s.Served != 0 || s.Failed != 0 {
+		t.Errorf("the losing proxy was counted: %+v", s)
+	}
+}
+
+//TestProxyPoolSocksConnections checks a connection through a socks proxy is not reused by a request
+//sent through another proxy: each proxy reaches its own server, which gets the requests of its proxy only.
+func TestProxyPoolSocksConnections(t *testing.T) {
+	_, data := randomFile(t, 4<<20)
+	one, two := newSegmentServer(t, data), newSegmentServer(t, data)
+	socksone := newSocksStandIn(t, "", "", one.testServer)
+	sockstwo := newSocksStandIn(t, "", "", two.testServer)
+	pool, err := NewProxyPool(ProxyRoundRobin, "socks5://"+socksone.addr, "socks5://"+sockstwo.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	c := &Client{ProxyPool: pool}
+	w := &memWriterAt{}
+	if _, _, _, code, _, err := c.UrlGetToWriterAt("http://127.0.0.1:1/", nil, nil, nil, w, 4, time.Second, 5*time.Second); code != 200 || err != nil || !bytes.Equal(w.buf, data) {
+		t.Fatalf("httpretcode %d %v", code, err)
+	}
+	for _, p := range []struct {
+		addr string
+		srv  *segmentServer
+	}{{socksone.addr, one}, {sockstwo.addr, two}} {
+		if s := poolStat(pool, p.addr); s.Served != int64(p.srv.requests()) {
+			t.Errorf("%s served %d requests, its server got %d", p.addr, s.Served, p.srv.requests())
+		}
+	}
+}
+
+//TestProxyPoolTargetFailures checks only the failures of the proxy itself are counted.
+func TestProxyPoolTargetFailures(t *testing.T) {
+	p := newPoolProxy(t, newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	closed := newNamedBackend(t, "closed", 0)
+	closed.Close()
+	socks := newSocksStandIn(t, "", "", closed)
+	pool, err := NewProxyPool(ProxyRoundRobin, "http://u:p@"+p.addr, "socks5h://"+socks.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.MaxFailures = 1
+	c := &Client{ProxyPool: pool}
+
+	//a target answering 500, then a target the socks proxy cannot reach
+	if _, _, _, code, _ := c.UrlGet("http://pool.test/", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 500 {
+		t.Errorf("target error: httpretcode %d", code)
+	}
+	if _, _, _, code, _ := c.UrlGet("http://pool.test/", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 2 {
+		t.Errorf("unreachable target: httpretcode %d", code)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.WithContext(ctx).UrlGet("http://pool.test/", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	for _, s := range pool.Stats() {
+		if !s.Healthy || s.Failed != 0 {
+			t.Errorf("%v counted a failure of the target or the caller: %+v", s.URL, s)
+		}
+	}
+
+	//a CONNECT refused for bad credentials is a failure of the proxy
+	bad, err := NewProxyPool(ProxyRoundRobin, "http://u:wrong@"+p.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	if _, _, _, code, _ := (&Client{ProxyPool: bad}).UrlGet("https://pool.test/", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 2 {
+		t.Errorf("refused CONNECT: httpretcode %d", code)
+	}
+	if s := poolStat(bad, p.addr); s.Failed != 1 {
+		t.Errorf("refused CONNECT not counted: %+v", s)
+	}
+}
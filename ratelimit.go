@@ -0,0 +1,155 @@
+// netutil project ratelimit.go
+package netutil
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+//RateLimiter is a token bucket limiting bytes per second, it can be shared by any number of
+//transfers. Set it as Client.RateLimit for the requests of a Client, on a copy of a Client for
+//some requests only, or as GlobalRateLimit for every transfer of the package. The zero RateLimiter
+//is unlimited.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 //0 is unlimited, as for the zero RateLimiter
+	burst  int64
+	tokens float64
+	last   time.Time
+}
+
+//GlobalRateLimit, when not nil, limits all request and response bodies of the package together.
+var GlobalRateLimit *RateLimiter
+
+//NewRateLimiter allows bytespersec bytes per second with bursts of burst bytes,
+//burst <= 0 means bytespersec, bytespersec <= 0 means unlimited.
+func NewRateLimiter(bytespersec, burst int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(bytespersec, burst)
+	return l
+}
+
+//SetRate changes the limit, also while transfers are running.
+func (l *RateLimiter) SetRate(bytespersec, burst int64) {
+	if bytespersec < 0 {
+		bytespersec = 0
+	}
+	if burst <= 0 {
+		burst = bytespersec
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		//an unlimited limiter took no tokens, it starts with a full burst
+		l.tokens = float64(burst)
+	}
+	l.rate = float64(bytespersec)
+	l.burst = burst
+	if l.tokens > float64(burst) {
+		l.tokens = float64(burst)
+	}
+}
+
+//maxChunk is the largest read which can pass the limiter at once.
+func (l *RateLimiter) maxChunk() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return math.MaxInt32
+	}
+	return int(l.burst)
+}
+
+//reserve takes n tokens and returns how long to wait until they are available.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+//unreserve gives back n tokens taken by reserve.
+func (l *RateLimiter) unreserve(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens += float64(n)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+}
+
+//WaitN blocks until n bytes may be transferred or ctx is done, then the bytes are given back
+//to the limiter and the error of ctx is returned.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	d := l.reserve(n)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.unreserve(n)
+		return ctx.Err()
+	}
+}
+
+//throttledReader passes a body through limiters, reads are cut to the smallest burst.
+//the waits end with ctx, the context of the request.
+type throttledReader struct {
+	ctx      context.Context
+	rc       io.ReadCloser
+	limiters []*RateLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	for _, l := range r.limiters {
+		if max := l.maxChunk(); len(p) > max {
+			p = p[:max]
+		}
+	}
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		for _, l := range r.limiters {
+			if werr := l.WaitN(r.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+func (r *throttledReader) Close() error {
+	return r.rc.Close()
+}
+
+//rateLimiters returns the limiters applying to the transfers of c.
+func (c *Client) rateLimiters() []*RateLimiter {
+	var limiters []*RateLimiter
+	if c.RateLimit != nil {
+		limiters = append(limiters, c.RateLimit)
+	}
+	if GlobalRateLimit != nil {
+		limiters = append(limiters, GlobalRateLimit)
+	}
+	return limiters
+}
@@ -0,0 +1,131 @@
+// netutil project ratelimit_test.go
+package netutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWaitN(t *testing.T) {
+	l := NewRateLimiter(1000, 100)
+	start := time.Now()
+	if d := l.reserve(100); d != 0 {
+		t.Fatalf("the burst has to wait %v", d)
+	}
+	if err := l.WaitN(context.Background(), 100); err != nil || time.Since(start) < 80*time.Millisecond {
+		t.Errorf("past the burst waited only %v %v", time.Since(start), err)
+	}
+
+	//a cancelled wait returns at once and gives its bytes back
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := l.WaitN(ctx, 1000); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 200*time.Millisecond {
+		t.Fatalf("cancelled WaitN returned %v after %v", err, time.Since(start))
+	}
+	if d := l.reserve(50); d > 150*time.Millisecond {
+		t.Errorf("the bytes of the cancelled wait were kept, 50 bytes have to wait %v", d)
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	l := NewRateLimiter(100, 10)
+	l.WaitN(context.Background(), 10)
+	l.SetRate(100000, 1000)
+	if d := l.reserve(500); d > 5*time.Millisecond {
+		t.Errorf("the new rate was not used, 500 bytes have to wait %v", d)
+	}
+}
+
+//TestRateLimiterUnlimited checks a rate <= 0, as in the zero RateLimiter, does not limit.
+func TestRateLimiterUnlimited(t *testing.T) {
+	for _, l := range []*RateLimiter{{}, NewRateLimiter(0, 0), NewRateLimiter(-1, 10)} {
+		if err := l.WaitN(context.Background(), 1<<20); err != nil || l.reserve(1<<20) != 0 || l.maxChunk() < 1<<20 {
+			t.Errorf("rate %v: %v, maxChunk %d", l.rate, err, l.maxChunk())
+		}
+	}
+	srv := newBytesServer(t, 8000)
+	if content, _, _, code, _ := (&Client{RateLimit: &RateLimiter{}}).UrlGet(srv.URL+"/get", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 200 || len(content) != 8000 {
+		t.Errorf("zero RateLimiter: httpretcode %d, %d bytes", code, len(content))
+	}
+
+	//a limit set later starts with a full burst
+	l := &RateLimiter{}
+	l.WaitN(context.Background(), 1<<20)
+	l.SetRate(1000, 100)
+	if d := l.reserve(100); d != 0 {
+		t.Errorf("the burst after SetRate has to wait %v", d)
+	}
+}
+
+//newBytesServer answers /get with size bytes and /post with the length of the request body.
+func newBytesServer(t *testing.T, size int) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/post" {
+			b, _ := ioutil.ReadAll(r.Body)
+			w.Write([]byte(strings.Repeat("-", len(b)/1000)))
+			return
+		}
+		w.Write(bytes.Repeat([]byte("x"), size))
+	})
+}
+
+func TestClientRateLimit(t *testing.T) {
+	srv := newBytesServer(t, 8000)
+	c := &Client{RateLimit: NewRateLimiter(16000, 2000)}
+	start := time.Now()
+	content, _, _, code, _ := c.UrlGet(srv.URL+"/get", nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	if code != 200 || len(content) != 8000 {
+		t.Fatalf("httpretcode %d, %d bytes", code, len(content))
+	}
+	//6000 bytes past the burst at 16000 per second
+	if took := time.Since(start); took < 300*time.Millisecond {
+		t.Errorf("download took only %v", took)
+	}
+
+	start = time.Now()
+	content, _, _, code, _ = c.UrlPost(srv.URL+"/post", []string{"data", strings.Repeat("y", 8000)}, false, nil, nil, time.Second, 5*time.Second)
+	if code != 200 || string(content) != "--------" {
+		t.Fatalf("upload: httpretcode %d %q", code, content)
+	}
+	if took := time.Since(start); took < 300*time.Millisecond {
+		t.Errorf("upload took only %v", took)
+	}
+}
+
+//TestRateLimitShared checks transfers sharing a limiter, through a Client or GlobalRateLimit,
+//get the rate together.
+func TestRateLimitShared(t *testing.T) {
+	srv := newBytesServer(t, 4000)
+	fetch := func(c *Client) time.Duration {
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if content, _, _, code, _ := c.UrlGet(srv.URL+"/get", nil, false, nil, nil, time.Second, 5*time.Second, nil); code != 200 || len(content) != 4000 {
+					t.Errorf("httpretcode %d, %d bytes", code, len(content))
+				}
+			}()
+		}
+		wg.Wait()
+		return time.Since(start)
+	}
+	if took := fetch(&Client{RateLimit: NewRateLimiter(16000, 2000)}); took < 300*time.Millisecond {
+		t.Errorf("Client.RateLimit: 2 transfers took only %v", took)
+	}
+
+	GlobalRateLimit = NewRateLimiter(16000, 2000)
+	defer func() { GlobalRateLimit = nil }()
+	if took := fetch(&Client{}); took < 300*time.Millisecond {
+		t.Errorf("GlobalRateLimit: 2 transfers took only %v", took)
+	}
+}
@@ -0,0 +1,96 @@
+// netutil project redirect.go
+package netutil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//how credentials are handled when a redirect leaves the host, see RedirectPolicy.Credentials
+const (
+	//RedirectCredentialsDefault keeps Authorization and Cookie for the same domain and its subdomains, like net/http
+	RedirectCredentialsDefault = iota
+	//RedirectCredentialsKeep sends Authorization and Cookie to any host
+	RedirectCredentialsKeep
+	//RedirectCredentialsStrip removes Authorization and Cookie whenever the host changes
+	RedirectCredentialsStrip
+)
+
+//RedirectPolicy controls how the Url* methods follow redirects, a nil policy stops at the 10th redirect like before.
+type RedirectPolicy struct {
+	//MaxHops is the maximum number of redirects followed, 0 stops at the 10th redirect (9 are followed).
+	MaxHops int
+	//NoFollow returns the 3xx response itself, redilocation is its resolved Location.
+	NoFollow bool
+	//SameHost refuses redirects to another host, HTTPSOnly refuses redirects to http urls.
+	SameHost  bool
+	HTTPSOnly bool
+	//Credentials is one of the RedirectCredentials constants.
+	Credentials int
+}
+
+//RedirectHop is one followed redirect, the response of URL which pointed to Location.
+type RedirectHop struct {
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Location   string
+}
+
+//RedirectError is returned when the policy refuses a redirect.
+type RedirectError struct {
+	Location string
+	Reason   string
+}
+
+func (e *RedirectError) Error() string {
+	return "redirect to " + e.Location + " refused: " + e.Reason
+}
+
+//checkRedirect is the CheckRedirect of the http.Client of one call, req is the next request.
+func (c *Client) checkRedirect(hc *httpClient, req *http.Request, via []*http.Request) error {
+	policy := c.Redirect
+	if policy == nil {
+		policy = &RedirectPolicy{}
+	}
+	if policy.NoFollow {
+		hc.setLocation(req.URL.String())
+		return http.ErrUseLastResponse
+	}
+	if policy.MaxHops <= 0 {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+	} else if len(via) > policy.MaxHops {
+		return fmt.Errorf("stopped after %d redirects", policy.MaxHops)
+	}
+	if policy.SameHost && !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		return &RedirectError{req.URL.String(), "different host"}
+	}
+	if policy.HTTPSOnly && req.URL.Scheme != "https" {
+		return &RedirectError{req.URL.String(), "not https"}
+	}
+	switch policy.Credentials {
+	case RedirectCredentialsKeep:
+		for _, key := range []string{"Authorization", "Cookie"} {
+			if v, ok := via[0].Header[key]; ok && req.Header.Get(key) == "" {
+				req.Header[key] = v
+			}
+		}
+	case RedirectCredentialsStrip:
+		if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+			req.Header.Del("Authorization")
+			req.Header.Del("Cookie")
+		}
+	}
+	//only the hops the policy accepted are recorded
+	hop := RedirectHop{URL: via[len(via)-1].URL.String(), Location: req.URL.String()}
+	if req.Response != nil {
+		hop.StatusCode = req.Response.StatusCode
+		hop.Header = req.Response.Header
+	}
+	hc.addRedirect(hop)
+	return nil
+}
@@ -0,0 +1,145 @@
+// netutil project redirect_test.go
+package netutil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//newRedirectServer redirects /a to /b to /c, /x to /o of another host, /loop/n to /loop/n+1
+//and /plain to the http url of another host, every page reports the credentials it got.
+func newRedirectServer(t *testing.T) (srv, other *testServer) {
+	report := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s auth=%s cookie=%s", r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Cookie"))
+	}
+	other = newTestServer(t, report)
+	srv = newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case r.URL.Path == "/b":
+			w.Header().Set("X-Hop", "b")
+			http.Redirect(w, r, "/c", http.StatusFound)
+		case r.URL.Path == "/x":
+			//another host name for the same address
+			http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1)+"/o", http.StatusTemporaryRedirect)
+		case strings.HasPrefix(r.URL.Path, "/loop/"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/loop/"))
+			http.Redirect(w, r, "/loop/"+strconv.Itoa(n+1), http.StatusFound)
+		default:
+			report(w, r)
+		}
+	})
+	return srv, other
+}
+
+type redirectResult struct {
+	content  string
+	code     int
+	location string
+	meta     *ResponseMeta
+}
+
+func redirectGet(policy *RedirectPolicy, httpurl string) redirectResult {
+	var res redirectResult
+	c := &Client{Redirect: policy, OnResponse: func(meta *ResponseMeta) { res.meta = meta }}
+	content, _, _, code, location := c.UrlGet(httpurl, nil, false, []string{"Authorization", "Bearer t"}, []*http.Cookie{{Name: "s", Value: "1"}}, time.Second, 5*time.Second, nil)
+	res.content, res.code, res.location = string(content), code, location
+	return res
+}
+
+func TestRedirectFollow(t *testing.T) {
+	srv, _ := newRedirectServer(t)
+	res := redirectGet(nil, srv.URL+"/a")
+	if res.code != 200 || res.content != "/c auth=Bearer t cookie=s=1" || res.location != srv.URL+"/c" {
+		t.Fatalf("httpretcode %d %q redilocation %q", res.code, res.content, res.location)
+	}
+	hops := res.meta.Redirects
+	if len(hops) != 2 {
+		t.Fatalf("%d hops recorded, want 2", len(hops))
+	}
+	if hops[0].URL != srv.URL+"/a" || hops[0].StatusCode != 301 || hops[0].Location != srv.URL+"/b" {
+		t.Errorf("first hop %+v", hops[0])
+	}
+	if hops[1].URL != srv.URL+"/b" || hops[1].StatusCode != 302 || hops[1].Location != srv.URL+"/c" || hops[1].Header.Get("X-Hop") != "b" {
+		t.Errorf("second hop %+v", hops[1])
+	}
+	if res.meta.Request.URL.Path != "/c" {
+		t.Errorf("ResponseMeta.Request %v", res.meta.Request.URL)
+	}
+}
+
+func TestRedirectNoFollow(t *testing.T) {
+	srv, _ := newRedirectServer(t)
+	res := redirectGet(&RedirectPolicy{NoFollow: true}, srv.URL+"/a")
+	if res.code != 301 || res.location != srv.URL+"/b" || len(res.meta.Redirects) != 0 {
+		t.Errorf("httpretcode %d redilocation %q hops %v", res.code, res.location, res.meta.Redirects)
+	}
+}
+
+func TestRedirectMaxHops(t *testing.T) {
+	srv, _ := newRedirectServer(t)
+	res := redirectGet(&RedirectPolicy{MaxHops: 1}, srv.URL+"/a")
+	if res.code != 2 || len(res.meta.Redirects) != 1 || res.location != srv.URL+"/b" {
+		t.Errorf("MaxHops 1: httpretcode %d redilocation %q hops %v", res.code, res.location, res.meta.Redirects)
+	}
+	res = redirectGet(&RedirectPolicy{MaxHops: 2}, srv.URL+"/a")
+	if res.code != 200 {
+		t.Errorf("MaxHops 2: httpretcode %d", res.code)
+	}
+
+	//without a limit the 10th redirect stops like net/http
+	res = redirectGet(nil, srv.URL+"/loop/0")
+	if res.code != 2 || len(res.meta.Redirects) != 9 || res.location != srv.URL+"/loop/9" {
+		t.Errorf("default: httpretcode %d redilocation %q %d hops", res.code, res.location, len(res.meta.Redirects))
+	}
+	if res.meta.Err == nil || !strings.Contains(res.meta.Err.Error(), "stopped after 10 redirects") {
+		t.Errorf("default: error %v", res.meta.Err)
+	}
+}
+
+//TestRedirectRefused checks a redirect refused by the policy is not recorded as a hop.
+func TestRedirectRefused(t *testing.T) {
+	srv, _ := newRedirectServer(t)
+	for _, tc := range []struct {
+		policy *RedirectPolicy
+		path   string
+		reason string
+	}{
+		{&RedirectPolicy{SameHost: true}, "/x", "different host"},
+		{&RedirectPolicy{HTTPSOnly: true}, "/a", "not https"},
+	} {
+		res := redirectGet(tc.policy, srv.URL+tc.path)
+		var rerr *RedirectError
+		if res.code != 2 || !errors.As(res.meta.Err, &rerr) || rerr.Reason != tc.reason {
+			t.Errorf("%s: httpretcode %d error %v", tc.reason, res.code, res.meta.Err)
+		}
+		if len(res.meta.Redirects) != 0 || res.location != "" {
+			t.Errorf("%s: refused redirect recorded: redilocation %q hops %v", tc.reason, res.location, res.meta.Redirects)
+		}
+	}
+}
+
+func TestRedirectCredentials(t *testing.T) {
+	srv, _ := newRedirectServer(t)
+	for _, tc := range []struct {
+		name        string
+		credentials int
+		path, want  string
+	}{
+		{"default other host", RedirectCredentialsDefault, "/x", "/o auth= cookie="},
+		{"keep other host", RedirectCredentialsKeep, "/x", "/o auth=Bearer t cookie=s=1"},
+		{"strip other host", RedirectCredentialsStrip, "/x", "/o auth= cookie="},
+		{"strip same host", RedirectCredentialsStrip, "/a", "/c auth=Bearer t cookie=s=1"},
+	} {
+		res := redirectGet(&RedirectPolicy{Credentials: tc.credentials}, srv.URL+tc.path)
+		if res.code != 200 || res.content != tc.want {
+			t.Errorf("%s: httpretcode %d %q, want %q", tc.name, res.code, res.content, tc.want)
+		}
+	}
+}
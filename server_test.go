@@ -0,0 +1,78 @@
+// netutil project server_test.go
+package netutil
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//testServer is the httptest.Server of a test, closed when the test ends. It counts the requests
+//and the connections it got.
+type testServer struct {
+	*httptest.Server
+	hits  int32
+	conns int32
+	open  int32
+}
+
+//newTestServer serves handler over http.
+func newTestServer(t *testing.T, handler http.HandlerFunc) *testServer {
+	s := newUnstartedTestServer(t, handler)
+	s.Start()
+	return s
+}
+
+//newTestTLSServer serves handler over https, setup, when not nil, changes the server config.
+func newTestTLSServer(t *testing.T, handler http.HandlerFunc, setup func(*tls.Config)) *testServer {
+	s := newUnstartedTestServer(t, handler)
+	s.TLS = &tls.Config{}
+	if setup != nil {
+		setup(s.TLS)
+	}
+	s.StartTLS()
+	return s
+}
+
+func newUnstartedTestServer(t *testing.T, handler http.HandlerFunc) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.hits, 1)
+		handler(w, r)
+	}))
+	s.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt32(&s.conns, 1)
+			atomic.AddInt32(&s.open, 1)
+		case http.StateHijacked, http.StateClosed:
+			atomic.AddInt32(&s.open, -1)
+		}
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+//requests returns the number of requests received.
+func (s *testServer) requests() int {
+	return int(atomic.LoadInt32(&s.hits))
+}
+
+//connections returns the number of connections accepted.
+func (s *testServer) connections() int {
+	return int(atomic.LoadInt32(&s.conns))
+}
+
+//closed waits up to a second for the clients to close their connections and reports whether
+//they all did.
+func (s *testServer) closed() bool {
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&s.open) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return atomic.LoadInt32(&s.open) == 0
+}
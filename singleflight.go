@@ -0,0 +1,136 @@
+// netutil project singleflight.go
+package netutil
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//SingleFlight lets concurrent identical GET requests share one request, set it as Client.SingleFlight
+//and share it between Clients. Requests are identical with the same url and header fields, cookies
+//included, or only the fields of KeyHeaders and the credentials when KeyHeaders is set. The shared
+//response body is read into memory and every caller gets its own copy of the response. The shared
+//request is canceled only when all its callers gave up.
+//
+//The shared request is sent with the settings of the Client which started it, its proxy, TLS options,
+//timeouts and limits; share a SingleFlight only between Clients which may use each other's settings.
+type SingleFlight struct {
+	//KeyHeaders, when not empty, are the only request header fields telling requests apart besides
+	//Authorization, Proxy-Authorization and Cookie, which always do.
+	KeyHeaders []string
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	waiters int
+	cancel  context.CancelFunc
+	proxy   *requestProxy
+	resp    *http.Response
+	body    []byte
+	err     error
+}
+
+func (s *SingleFlight) key(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method + " " + req.URL.String())
+	names := map[string]bool{}
+	if len(s.KeyHeaders) == 0 {
+		for name := range req.Header {
+			names[name] = true
+		}
+	} else {
+		//requests of different users never share a response
+		for _, name := range append([]string{"Authorization", "Proxy-Authorization", "Cookie"}, s.KeyHeaders...) {
+			names[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		b.WriteString("\n" + name + ": " + strings.Join(req.Header.Values(name), "\x00"))
+	}
+	return b.String()
+}
+
+//singleFlightTransport shares the GET requests in flight through next.
+type singleFlightTransport struct {
+	group *SingleFlight
+	next  http.RoundTripper
+}
+
+func (t *singleFlightTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" || req.Body != nil && req.Body != http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+	s := t.group
+	key := s.key(req)
+	s.mu.Lock()
+	if s.flights == nil {
+		s.flights = map[string]*flight{}
+	}
+	f, ok := s.flights[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx, proxy := withRequestProxy(ctx)
+		f = &flight{done: make(chan struct{}), cancel: cancel, proxy: proxy}
+		s.flights[key] = f
+		go t.run(key, f, req.Clone(ctx))
+	}
+	f.waiters++
+	s.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-req.Context().Done():
+		s.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.cancel()
+			//a request arriving now must not join the canceled one
+			if s.flights[key] == f {
+				delete(s.flights, key)
+			}
+		}
+		s.mu.Unlock()
+		return nil, req.Context().Err()
+	}
+	requestProxyFrom(req.Context()).copy(f.proxy)
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := *f.resp
+	resp.Header = f.resp.Header.Clone()
+	resp.Trailer = f.resp.Trailer.Clone()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(f.body))
+	resp.ContentLength = int64(len(f.body))
+	resp.Request = req
+	return &resp, nil
+}
+
+//run sends the shared request and reads its body, the flight is removed before its callers
+//are released, so later requests start a new one.
+func (t *singleFlightTransport) run(key string, f *flight, req *http.Request) {
+	defer f.cancel()
+	f.resp, f.err = t.next.RoundTrip(req)
+	if f.err == nil {
+		f.body, f.err = ioutil.ReadAll(f.resp.Body)
+		f.resp.Body.Close()
+	}
+	t.group.mu.Lock()
+	if t.group.flights[key] == f {
+		delete(t.group.flights, key)
+	}
+	t.group.mu.Unlock()
+	close(f.done)
+}
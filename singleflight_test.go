@@ -0,0 +1,148 @@
+// netutil project singleflight_test.go
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newSharedServer answers the number of the request, its Authorization and Cookie after 150ms
+//and counts the requests canceled before.
+func newSharedServer(t *testing.T) (srv *testServer, hits, canceled *int32) {
+	hits, canceled = new(int32), new(int32)
+	srv = newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		i := atomic.AddInt32(hits, 1)
+		select {
+		case <-time.After(150 * time.Millisecond):
+		case <-r.Context().Done():
+			atomic.AddInt32(canceled, 1)
+			return
+		}
+		fmt.Fprintf(w, "%d %s %s", i, r.Header.Get("Authorization"), r.Header.Get("Cookie"))
+	})
+	return srv, hits, canceled
+}
+
+//sharedGets sends the requests of httpsendheads at once through c and returns the contents.
+func sharedGets(c *Client, httpurl string, httpsendheads ...[]string) []string {
+	contents := make([]string, len(httpsendheads))
+	var wg sync.WaitGroup
+	for i, head := range httpsendheads {
+		wg.Add(1)
+		go func(i int, head []string) {
+			defer wg.Done()
+			content, _, _, code, _ := c.UrlGet(httpurl, nil, false, head, nil, time.Second, 5*time.Second, nil)
+			contents[i] = fmt.Sprint(code, " ", string(content))
+		}(i, head)
+	}
+	wg.Wait()
+	return contents
+}
+
+func TestSingleFlight(t *testing.T) {
+	srv, hits, _ := newSharedServer(t)
+	c := &Client{SingleFlight: &SingleFlight{}}
+	contents := sharedGets(c, srv.URL, nil, nil, nil, nil, nil)
+	for _, content := range contents {
+		if content != "200 1  " {
+			t.Errorf("content %q, want the shared response", content)
+		}
+	}
+	if atomic.LoadInt32(hits) != 1 {
+		t.Errorf("%d requests sent for 5 identical ones", atomic.LoadInt32(hits))
+	}
+
+	//a request after the flight landed starts a new one
+	if content := sharedGets(c, srv.URL, nil)[0]; content != "200 2  " {
+		t.Errorf("later request: %q", content)
+	}
+	//without KeyHeaders every header field tells requests apart
+	sharedGets(c, srv.URL, []string{"X-Trace", "1"}, []string{"X-Trace", "2"})
+	if atomic.LoadInt32(hits) != 4 {
+		t.Errorf("%d requests, want 4 after 2 different ones", atomic.LoadInt32(hits))
+	}
+	//other methods are never shared
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.UrlPost(srv.URL, []string{"a", "b"}, false, nil, nil, time.Second, 5*time.Second)
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(hits) != 6 {
+		t.Errorf("%d requests, want 6 after 2 POST", atomic.LoadInt32(hits))
+	}
+}
+
+//TestSingleFlightKeyHeaders checks the credentials always tell requests apart, whatever KeyHeaders says.
+func TestSingleFlightKeyHeaders(t *testing.T) {
+	srv, hits, _ := newSharedServer(t)
+	c := &Client{SingleFlight: &SingleFlight{KeyHeaders: []string{"x-tenant"}}}
+
+	contents := sharedGets(c, srv.URL, []string{"X-Tenant", "a", "X-Trace", "1"}, []string{"X-Tenant", "a", "X-Trace", "2"})
+	if atomic.LoadInt32(hits) != 1 || contents[0] != contents[1] {
+		t.Errorf("fields outside KeyHeaders: %d requests, %q", atomic.LoadInt32(hits), contents)
+	}
+	sharedGets(c, srv.URL, []string{"X-Tenant", "a"}, []string{"X-Tenant", "b"})
+	if atomic.LoadInt32(hits) != 3 {
+		t.Errorf("different KeyHeaders: %d requests, want 3", atomic.LoadInt32(hits))
+	}
+
+	atomic.StoreInt32(hits, 0)
+	contents = sharedGets(c, srv.URL, []string{"Authorization", "Bearer alice"}, []string{"Authorization", "Bearer bob"}, []string{"Cookie", "s=1"}, []string{"Cookie", "s=2"})
+	if atomic.LoadInt32(hits) != 4 {
+		t.Errorf("different credentials: %d requests, want 4", atomic.LoadInt32(hits))
+	}
+	for i, want := range []string{"Bearer alice ", "Bearer bob ", " s=1", " s=2"} {
+		if !strings.HasSuffix(contents[i], want) {
+			t.Errorf("request %d got %q, want its own credentials %q", i, contents[i], want)
+		}
+	}
+}
+
+func TestSingleFlightCancel(t *testing.T) {
+	srv, hits, canceled := newSharedServer(t)
+	c := &Client{SingleFlight: &SingleFlight{}}
+
+	//a caller giving up does not cancel the request of the others
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	var gaveup, shared int
+	for i, cc := range []*Client{c.WithContext(ctx), c} {
+		wg.Add(1)
+		go func(i int, cc *Client) {
+			defer wg.Done()
+			_, _, _, code, _ := cc.UrlGet(srv.URL, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+			if i == 0 {
+				gaveup = code
+			} else {
+				shared = code
+			}
+		}(i, cc)
+	}
+	wg.Wait()
+	if gaveup != 2 || shared != 200 || atomic.LoadInt32(hits) != 1 || atomic.LoadInt32(canceled) != 0 {
+		t.Errorf("httpretcodes %d and %d, %d requests, %d canceled", gaveup, shared, atomic.LoadInt32(hits), atomic.LoadInt32(canceled))
+	}
+
+	//the shared request is canceled when all its callers gave up
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	sharedGets(c.WithContext(ctx), srv.URL, nil, nil)
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(canceled) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(hits) != 2 || atomic.LoadInt32(canceled) != 1 {
+		t.Errorf("all callers gave up: %d requests, %d canceled", atomic.LoadInt32(hits), atomic.LoadInt32(canceled))
+	}
+}
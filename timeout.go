@@ -0,0 +1,40 @@
+// netutil project timeout.go
+package netutil
+
+import (
+	"net"
+	"time"
+)
+
+//Timeouts replaces the single absolute deadline set on the connection by datatrantimeout,
+//a zero field means no limit. With Timeouts set, contimeout and datatrantimeout of the Url*
+//functions are only the defaults of Dial and Idle.
+type Timeouts struct {
+	//Dial limits establishing the TCP connection, to the server or the proxy.
+	Dial time.Duration
+	//TLSHandshake limits the TLS handshake.
+	TLSHandshake time.Duration
+	//ResponseHeader limits the wait for the response headers after the request is written.
+	ResponseHeader time.Duration
+	//Request limits the whole request including redirects and reading the response body.
+	Request time.Duration
+	//Idle fails a transfer when no byte is read or written for this long, a slow but
+	//steady transfer is never cut.
+	Idle time.Duration
+}
+
+//idleConn moves the read or write deadline forward before every read or write.
+type idleConn struct {
+	net.Conn
+	idle time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.idle))
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.idle))
+	return c.Conn.Write(p)
+}
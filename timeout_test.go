@@ -0,0 +1,96 @@
+// netutil project timeout_test.go
+package netutil
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+//newTimeoutServer serves /steady, 10 bytes one every 50ms, /stall, a byte then a 500ms pause,
+//and /slowhead, headers after 300ms.
+func newTimeoutServer(t *testing.T) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/steady":
+			for i := 0; i < 10; i++ {
+				w.Write([]byte("x"))
+				w.(http.Flusher).Flush()
+				time.Sleep(50 * time.Millisecond)
+			}
+		case "/stall":
+			w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			time.Sleep(500 * time.Millisecond)
+			w.Write([]byte("y"))
+		case "/slowhead":
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("z"))
+		}
+	})
+}
+
+//timedGet returns the content, the httpretcode and how long the call took.
+func timedGet(c *Client, httpurl string, datatrantimeout time.Duration) (string, int, time.Duration) {
+	start := time.Now()
+	content, _, _, code, _ := c.UrlGet(httpurl, nil, false, nil, nil, time.Second, datatrantimeout, nil)
+	return string(content), code, time.Since(start)
+}
+
+func TestTimeoutsIdle(t *testing.T) {
+	srv := newTimeoutServer(t)
+
+	//the absolute deadline cuts a steady transfer, Idle does not
+	if content, _, _ := timedGet(&Client{}, srv.URL+"/steady", 200*time.Millisecond); content != "" {
+		t.Errorf("datatrantimeout: read %q", content)
+	}
+	if content, code, _ := timedGet(&Client{Timeouts: &Timeouts{Idle: 200 * time.Millisecond}}, srv.URL+"/steady", 0); code != 200 || content != "xxxxxxxxxx" {
+		t.Errorf("Idle, steady: httpretcode %d %q", code, content)
+	}
+	content, _, took := timedGet(&Client{Timeouts: &Timeouts{Idle: 200 * time.Millisecond}}, srv.URL+"/stall", 0)
+	if content != "" || took > 400*time.Millisecond {
+		t.Errorf("Idle, stall: read %q in %v", content, took)
+	}
+	//datatrantimeout is the default Idle
+	content, _, took = timedGet(&Client{Timeouts: &Timeouts{}}, srv.URL+"/stall", 200*time.Millisecond)
+	if content != "" || took > 400*time.Millisecond {
+		t.Errorf("Idle from datatrantimeout, stall: read %q in %v", content, took)
+	}
+}
+
+func TestTimeoutsResponseHeaderAndRequest(t *testing.T) {
+	srv := newTimeoutServer(t)
+	if _, code, took := timedGet(&Client{Timeouts: &Timeouts{ResponseHeader: 100 * time.Millisecond}}, srv.URL+"/slowhead", 0); code != 2 || took > 250*time.Millisecond {
+		t.Errorf("ResponseHeader: httpretcode %d in %v", code, took)
+	}
+	if content, code, _ := timedGet(&Client{Timeouts: &Timeouts{ResponseHeader: time.Second}}, srv.URL+"/slowhead", 0); code != 200 || content != "z" {
+		t.Errorf("ResponseHeader not reached: httpretcode %d %q", code, content)
+	}
+	content, _, took := timedGet(&Client{Timeouts: &Timeouts{Request: 200 * time.Millisecond}}, srv.URL+"/steady", 0)
+	if content != "" || took > 400*time.Millisecond {
+		t.Errorf("Request: read %q in %v", content, took)
+	}
+}
+
+func TestTimeoutsTLSHandshake(t *testing.T) {
+	//a server which accepts the connection and never answers the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	_, code, took := timedGet(&Client{Timeouts: &Timeouts{TLSHandshake: 100 * time.Millisecond}}, "https://"+ln.Addr().String()+"/", 0)
+	if code != 2 || took > 500*time.Millisecond {
+		t.Errorf("TLSHandshake: httpretcode %d in %v", code, took)
+	}
+}
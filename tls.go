@@ -0,0 +1,101 @@
+// netutil project tls.go
+package netutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+//TLSOptions configures the TLS connections to https servers and https proxies.
+type TLSOptions struct {
+	//RootCAFiles and RootCAPEM are PEM certificates trusted in addition to the system roots.
+	RootCAFiles []string
+	RootCAPEM   [][]byte
+	//OnlyRootCAs trusts only the given certificates, not the system roots.
+	OnlyRootCAs bool
+	//ClientCertFiles are certificate and key file pairs {certfile, keyfile} for mutual TLS.
+	ClientCertFiles [][2]string
+	ClientCerts     []tls.Certificate
+	//MinVersion is tls.VersionTLS12 and so on, 0 means the crypto/tls default.
+	MinVersion uint16
+	//CipherSuites restricts the TLS 1.0-1.2 cipher suites, TLS 1.3 suites are not configurable.
+	CipherSuites []uint16
+	//ServerName overrides the SNI and the name the server certificate is verified against.
+	ServerName string
+	//InsecureSkipVerifyForTesting accepts any server certificate, never use it in production.
+	InsecureSkipVerifyForTesting bool
+	//Pins maps a host, or "*.domain" for its subdomains, to base64 SHA-256 SPKI pins (see SPKIPin),
+	//the handshake fails with a *PinError unless a certificate of a verified chain matches one pin,
+	//with InsecureSkipVerifyForTesting only the leaf certificate is matched.
+	//hosts are matched case-insensitively by SNI name, so servers addressed by IP address are not pinned.
+	Pins map[string][]string
+	//PinReportOnly only reports mismatches to PinReport, or logs them with the log package when PinReport is nil.
+	PinReportOnly bool
+	PinReport     func(err *PinError)
+
+	once   sync.Once
+	config *tls.Config
+	err    error
+	//pins is Pins with lower case hosts
+	pins map[string][]string
+}
+
+//Config returns the tls.Config of the options, files are read once.
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	o.once.Do(func() {
+		o.config, o.err = o.build()
+	})
+	return o.config, o.err
+}
+
+func (o *TLSOptions) build() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         o.MinVersion,
+		CipherSuites:       o.CipherSuites,
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerifyForTesting,
+	}
+	if len(o.RootCAFiles) > 0 || len(o.RootCAPEM) > 0 || o.OnlyRootCAs {
+		pool := x509.NewCertPool()
+		if !o.OnlyRootCAs {
+			if syspool, err := x509.SystemCertPool(); err == nil {
+				pool = syspool
+			}
+		}
+		pems := append([][]byte(nil), o.RootCAPEM...)
+		for _, file := range o.RootCAFiles {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			pems = append(pems, pem)
+		}
+		for _, pem := range pems {
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("tls: no certificate found in root CA PEM")
+			}
+		}
+		config.RootCAs = pool
+	}
+	if len(o.Pins) > 0 {
+		o.pins = map[string][]string{}
+		for host, pins := range o.Pins {
+			host = strings.ToLower(host)
+			o.pins[host] = append(o.pins[host], pins...)
+		}
+		config.VerifyConnection = o.verifyPins
+	}
+	config.Certificates = append(config.Certificates, o.ClientCerts...)
+	for _, pair := range o.ClientCertFiles {
+		cert, err := tls.LoadX509KeyPair(pair[0], pair[1])
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	return config, nil
+}
@@ -0,0 +1,201 @@
+// netutil project tls_test.go
+package netutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pem    []byte
+	serial int64
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), serial: 1}
+}
+
+//issue returns a certificate for hosts, IP addresses or DNS names, valid until notafter,
+//a client certificate when client is set.
+func (ca *testCA) issue(t *testing.T, cn string, notafter time.Time, client bool, hosts ...string) (cert tls.Certificate, certpem, keypem []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     notafter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if client {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certpem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keypem = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	if cert, err = tls.X509KeyPair(append(certpem, ca.pem...), keypem); err != nil {
+		t.Fatal(err)
+	}
+	return cert, certpem, keypem
+}
+
+//newTLSTestServer serves https with cert, it answers with the client certificate name,
+//the TLS version and the SNI name. setup, when not nil, changes the server config.
+func newTLSTestServer(t *testing.T, cert tls.Certificate, setup func(*tls.Config)) *testServer {
+	return newTestTLSServer(t, func(w http.ResponseWriter, r *http.Request) {
+		client := "-"
+		if len(r.TLS.PeerCertificates) > 0 {
+			client = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		fmt.Fprintf(w, "%s %s %s", client, tls.VersionName(r.TLS.Version), r.TLS.ServerName)
+	}, func(config *tls.Config) {
+		config.Certificates = []tls.Certificate{cert}
+		if setup != nil {
+			setup(config)
+		}
+	})
+}
+
+func tlsGet(o *TLSOptions, httpurl string) (string, int) {
+	c := &Client{TLS: o}
+	content, _, _, code, _ := c.UrlGet(httpurl, nil, false, nil, nil, time.Second, 5*time.Second, nil)
+	return string(content), code
+}
+
+func TestTLSRootCAs(t *testing.T) {
+	ca := newTestCA(t, "test root")
+	cert, _, _ := ca.issue(t, "server", time.Now().Add(time.Hour), false, "127.0.0.1")
+	srv := newTLSTestServer(t, cert, nil)
+
+	if _, code := tlsGet(nil, srv.URL); code != 2 {
+		t.Errorf("unknown CA accepted: httpretcode %d", code)
+	}
+	if content, code := tlsGet(&TLSOptions{RootCAPEM: [][]byte{ca.pem}}, srv.URL); code != 200 {
+		t.Errorf("RootCAPEM: httpretcode %d %q", code, content)
+	}
+	cafile := writeTestFile(t, filepath.Join(t.TempDir(), "ca.pem"), string(ca.pem))
+	if content, code := tlsGet(&TLSOptions{RootCAFiles: []string{cafile}, OnlyRootCAs: true}, srv.URL); code != 200 {
+		t.Errorf("RootCAFiles: httpretcode %d %q", code, content)
+	}
+	other := newTestCA(t, "other root")
+	if _, code := tlsGet(&TLSOptions{RootCAPEM: [][]byte{other.pem}, OnlyRootCAs: true}, srv.URL); code != 2 {
+		t.Errorf("OnlyRootCAs with another CA: httpretcode %d, want 2", code)
+	}
+
+	//a bad configuration fails every request of the call
+	for _, o := range []*TLSOptions{
+		{RootCAFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}},
+		{RootCAPEM: [][]byte{[]byte("not a certificate")}},
+		{ClientCertFiles: [][2]string{{cafile, cafile}}},
+	} {
+		if _, err := o.Config(); err == nil {
+			t.Errorf("Config of %+v: no error", o)
+		}
+		if _, code := tlsGet(o, srv.URL); code != 2 {
+			t.Errorf("bad configuration: httpretcode %d, want 2", code)
+		}
+	}
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	ca := newTestCA(t, "test root")
+	cert, _, _ := ca.issue(t, "server", time.Now().Add(time.Hour), false, "127.0.0.1")
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv := newTLSTestServer(t, cert, func(config *tls.Config) {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = pool
+	})
+
+	clientcert, certpem, keypem := ca.issue(t, "alice", time.Now().Add(time.Hour), true)
+	dir := t.TempDir()
+	certfile := writeTestFile(t, filepath.Join(dir, "client.pem"), string(certpem))
+	keyfile := writeTestFile(t, filepath.Join(dir, "client.key"), string(keypem))
+
+	if _, code := tlsGet(&TLSOptions{RootCAPEM: [][]byte{ca.pem}}, srv.URL); code != 2 {
+		t.Errorf("without client certificate: httpretcode %d, want 2", code)
+	}
+	content, code := tlsGet(&TLSOptions{RootCAPEM: [][]byte{ca.pem}, ClientCertFiles: [][2]string{{certfile, keyfile}}}, srv.URL)
+	if code != 200 || !strings.HasPrefix(content, "alice ") {
+		t.Errorf("ClientCertFiles: httpretcode %d %q", code, content)
+	}
+	content, code = tlsGet(&TLSOptions{RootCAPEM: [][]byte{ca.pem}, ClientCerts: []tls.Certificate{clientcert}}, srv.URL)
+	if code != 200 || !strings.HasPrefix(content, "alice ") {
+		t.Errorf("ClientCerts: httpretcode %d %q", code, content)
+	}
+}
+
+func TestTLSVersionAndServerName(t *testing.T) {
+	ca := newTestCA(t, "test root")
+	cert, _, _ := ca.issue(t, "server", time.Now().Add(time.Hour), false, "svc.internal")
+	srv := newTLSTestServer(t, cert, func(config *tls.Config) {
+		config.MaxVersion = tls.VersionTLS12
+	})
+
+	//the certificate does not name the address
+	if _, code := tlsGet(&TLSOptions{RootCAPEM: [][]byte{ca.pem}}, srv.URL); code != 2 {
+		t.Errorf("without ServerName: httpretcode %d, want 2", code)
+	}
+	content, code := tlsGet(&TLSOptions{RootCAPEM: [][]byte{ca.pem}, ServerName: "svc.internal"}, srv.URL)
+	if code != 200 || content != "- TLS 1.2 svc.internal" {
+		t.Errorf("ServerName: httpretcode %d %q", code, content)
+	}
+	if _, code := tlsGet(&TLSOptions{RootCAPEM: [][]byte{ca.pem}, ServerName: "svc.internal", MinVersion: tls.VersionTLS13}, srv.URL); code != 2 {
+		t.Errorf("MinVersion TLS 1.3 against a TLS 1.2 server: httpretcode %d, want 2", code)
+	}
+	if _, code := tlsGet(&TLSOptions{InsecureSkipVerifyForTesting: true}, srv.URL); code != 200 {
+		t.Errorf("InsecureSkipVerifyForTesting: httpretcode %d", code)
+	}
+}
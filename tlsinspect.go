@@ -0,0 +1,129 @@
+// netutil project tlsinspect.go
+package netutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+//TLSInfo is the result of InspectTLS.
+type TLSInfo struct {
+	Addr       string
+	ServerName string
+	//Version and CipherSuite are names like "TLS 1.3" and "TLS_AES_128_GCM_SHA256".
+	Version     string
+	CipherSuite string
+	//NegotiatedProtocol is the ALPN protocol, "h2" or "http/1.1", empty if none.
+	NegotiatedProtocol string
+	//Chain is the certificate chain as sent by the server, leaf first.
+	Chain []*x509.Certificate
+	//SANs are the DNS names, IP addresses, emails and URIs of the leaf certificate.
+	SANs     []string
+	NotAfter time.Time
+	//DaysLeft is the number of whole days until the leaf expires, negative when expired.
+	DaysLeft int
+	//VerifyErr is the chain verification error against the roots and ServerName, nil if valid.
+	VerifyErr error
+	//Err is the connection or handshake error, Chain is empty then.
+	Err error
+}
+
+//InspectTLS connects to addr (host:port, port 443 if missing), performs the handshake and returns
+//the certificate chain and connection parameters. The chain is returned even when it does not verify,
+//see VerifyErr. servername defaults to options.ServerName, then the host of addr, options may be nil.
+func InspectTLS(addr, servername string, timeout time.Duration, options *TLSOptions) *TLSInfo {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "443")
+	}
+	info := &TLSInfo{Addr: addr, ServerName: servername}
+	if info.ServerName == "" && options != nil {
+		info.ServerName = options.ServerName
+	}
+	if info.ServerName == "" {
+		info.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	config := &tls.Config{}
+	if options != nil {
+		base, err := options.Config()
+		if err != nil {
+			info.Err = err
+			return info
+		}
+		config = base.Clone()
+	}
+	roots := config.RootCAs
+	//verify after the handshake, so an invalid chain can still be inspected
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = nil
+	config.ServerName = info.ServerName
+	config.NextProtos = []string{"h2", "http/1.1"}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, config)
+	if err != nil {
+		info.Err = err
+		return info
+	}
+	defer conn.Close()
+	cs := conn.ConnectionState()
+	info.Version = tls.VersionName(cs.Version)
+	info.CipherSuite = tls.CipherSuiteName(cs.CipherSuite)
+	info.NegotiatedProtocol = cs.NegotiatedProtocol
+	info.Chain = cs.PeerCertificates
+	if len(info.Chain) == 0 {
+		return info
+	}
+
+	leaf := info.Chain[0]
+	info.SANs = append(info.SANs, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	info.SANs = append(info.SANs, leaf.EmailAddresses...)
+	for _, u := range leaf.URIs {
+		info.SANs = append(info.SANs, u.String())
+	}
+	info.NotAfter = leaf.NotAfter
+	left := time.Until(leaf.NotAfter)
+	info.DaysLeft = int(left / (24 * time.Hour))
+	if left < 0 {
+		info.DaysLeft--
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range info.Chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, info.VerifyErr = leaf.Verify(x509.VerifyOptions{DNSName: info.ServerName, Roots: roots, Intermediates: intermediates})
+	return info
+}
+
+//InspectTLSHosts runs InspectTLS over addrs with at most concurrency connections at a time,
+//the results are in the order of addrs.
+func InspectTLSHosts(addrs []string, concurrency int, timeout time.Duration, options *TLSOptions) []*TLSInfo {
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+	infos := make([]*TLSInfo, len(addrs))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, addr string) {
+			defer wg.Done()
+			infos[i] = InspectTLS(addr, "", timeout, options)
+			<-sem
+		}(i, addr)
+	}
+	wg.Wait()
+	return infos
+}
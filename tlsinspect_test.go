@@ -0,0 +1,81 @@
+// netutil project tlsinspect_test.go
+package netutil
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInspectTLS(t *testing.T) {
+	ca := newTestCA(t, "inspect root")
+	cert, _, _ := ca.issue(t, "server", time.Now().Add(10*24*time.Hour+time.Hour), false, "svc.internal", "127.0.0.1")
+	srv := newTLSTestServer(t, cert, nil)
+	addr := srv.Listener.Addr().String()
+
+	info := InspectTLS(addr, "svc.internal", time.Second, &TLSOptions{RootCAPEM: [][]byte{ca.pem}})
+	if info.Err != nil {
+		t.Fatal(info.Err)
+	}
+	if info.VerifyErr != nil {
+		t.Errorf("VerifyErr %v", info.VerifyErr)
+	}
+	if info.Addr != addr || info.ServerName != "svc.internal" || info.Version != "TLS 1.3" || info.CipherSuite == "" || info.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("connection %+v", info)
+	}
+	if len(info.Chain) != 2 || info.Chain[0].Subject.CommonName != "server" || info.Chain[1].Subject.CommonName != "inspect root" {
+		t.Errorf("chain of %d certificates", len(info.Chain))
+	}
+	if strings.Join(info.SANs, ",") != "svc.internal,127.0.0.1" {
+		t.Errorf("SANs %v", info.SANs)
+	}
+	if info.DaysLeft != 10 || !info.NotAfter.Equal(info.Chain[0].NotAfter) {
+		t.Errorf("DaysLeft %d NotAfter %v", info.DaysLeft, info.NotAfter)
+	}
+
+	//an untrusted chain or a wrong name is still inspected
+	info = InspectTLS(addr, "", time.Second, nil)
+	if info.Err != nil || len(info.Chain) != 2 || info.VerifyErr == nil || info.ServerName != "127.0.0.1" {
+		t.Errorf("untrusted: Err %v, %d certificates, VerifyErr %v, ServerName %q", info.Err, len(info.Chain), info.VerifyErr, info.ServerName)
+	}
+	info = InspectTLS(addr, "", time.Second, &TLSOptions{RootCAPEM: [][]byte{ca.pem}, ServerName: "other.internal"})
+	if info.Err != nil || info.ServerName != "other.internal" || info.VerifyErr == nil {
+		t.Errorf("wrong name: Err %v ServerName %q VerifyErr %v", info.Err, info.ServerName, info.VerifyErr)
+	}
+}
+
+func TestInspectTLSExpired(t *testing.T) {
+	ca := newTestCA(t, "inspect root")
+	cert, _, _ := ca.issue(t, "server", time.Now().Add(-time.Hour), false, "127.0.0.1")
+	srv := newTLSTestServer(t, cert, nil)
+	info := InspectTLS(srv.Listener.Addr().String(), "", time.Second, &TLSOptions{RootCAPEM: [][]byte{ca.pem}})
+	if info.Err != nil {
+		t.Fatal(info.Err)
+	}
+	if info.DaysLeft != -1 || info.VerifyErr == nil {
+		t.Errorf("expired: DaysLeft %d VerifyErr %v", info.DaysLeft, info.VerifyErr)
+	}
+}
+
+func TestInspectTLSHosts(t *testing.T) {
+	ca := newTestCA(t, "inspect root")
+	cert, _, _ := ca.issue(t, "server", time.Now().Add(time.Hour), false, "127.0.0.1")
+	srv := newTLSTestServer(t, cert, nil)
+	addr := srv.Listener.Addr().String()
+
+	infos := InspectTLSHosts([]string{addr, "127.0.0.1:1", addr}, 2, time.Second, &TLSOptions{RootCAPEM: [][]byte{ca.pem}})
+	if len(infos) != 3 {
+		t.Fatalf("%d results", len(infos))
+	}
+	if infos[0].Err != nil || infos[0].Addr != addr || infos[2].Err != nil || infos[2].Addr != addr {
+		t.Errorf("results out of order or failed: %+v %+v", infos[0], infos[2])
+	}
+	if infos[1].Err == nil || infos[1].Addr != "127.0.0.1:1" || len(infos[1].Chain) != 0 {
+		t.Errorf("closed port: %+v", infos[1])
+	}
+	//a missing port means 443, a bad configuration fails before connecting
+	info := InspectTLS("127.0.0.1", "", time.Second, &TLSOptions{RootCAPEM: [][]byte{[]byte("bad")}})
+	if info.Addr != "127.0.0.1:443" || info.Err == nil {
+		t.Errorf("bad options: Addr %q Err %v", info.Addr, info.Err)
+	}
+}
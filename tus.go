@@ -0,0 +1,209 @@
+// netutil project tus.go
+package netutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//tus resumable upload protocol version, see https://tus.io/protocols/resumable-upload
+const TusVersion = "1.0.0"
+
+//tusRequest sends a tus request, body may be nil.
+func (c *Client) tusRequest(method, httpurl string, body io.Reader, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration, set func(h http.Header)) (*http.Response, int) {
+	var redilocation string
+	client := c.newHttpClient(contimeout, datatrantimeout, &redilocation)
+	request, err := http.NewRequest(method, httpurl, body)
+	if err != nil {
+		fmt.Println(err)
+		return nil, 1
+	}
+	setRequestHead(request, "", httpsendhead, cookie)
+	request.Header.Set("Tus-Resumable", TusVersion)
+	if set != nil {
+		set(request.Header)
+	}
+	response, err := c.do(client, request)
+	if err != nil {
+		fmt.Println(err)
+		return nil, doErrCode(err)
+	}
+	return response, response.StatusCode
+}
+
+//tusMetadata encodes metadata as Upload-Metadata, keys are sorted.
+func tusMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(metadata[k])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+//TusCreate creates an upload of size bytes at endpoint and returns its upload url,
+//httpretcode is 201 on success.
+func (c *Client) TusCreate(endpoint string, size int64, metadata map[string]string, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (uploadurl string, httpretcode int) {
+	if size < 0 {
+		return "", 3
+	}
+	response, code := c.tusRequest("POST", endpoint, nil, httpsendhead, cookie, contimeout, datatrantimeout, func(h http.Header) {
+		h.Set("Upload-Length", strconv.FormatInt(size, 10))
+		if len(metadata) > 0 {
+			h.Set("Upload-Metadata", tusMetadata(metadata))
+		}
+	})
+	if response == nil {
+		return "", code
+	}
+	defer response.Body.Close()
+	if code != http.StatusCreated {
+		return "", code
+	}
+	location, err := response.Location()
+	if err != nil {
+		return "", 6
+	}
+	return location.String(), code
+}
+
+//TusOffset returns the offset and length of an upload, httpretcode is 200 on success.
+func (c *Client) TusOffset(uploadurl string, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (offset, size int64, httpretcode int) {
+	response, code := c.tusRequest("HEAD", uploadurl, nil, httpsendhead, cookie, contimeout, datatrantimeout, nil)
+	if response == nil {
+		return 0, -1, code
+	}
+	defer response.Body.Close()
+	if code != http.StatusOK && code != http.StatusNoContent {
+		return 0, -1, code
+	}
+	offset, err := strconv.ParseInt(response.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, -1, 6
+	}
+	size = -1
+	if l := response.Header.Get("Upload-Length"); l != "" {
+		size, _ = strconv.ParseInt(l, 10, 64)
+	}
+	return offset, size, code
+}
+
+//TusPatch sends chunk at offset and returns the new offset, httpretcode is 204 on success.
+func (c *Client) TusPatch(uploadurl string, offset int64, chunk []byte, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (newoffset int64, httpretcode int) {
+	response, code := c.tusRequest("PATCH", uploadurl, bytes.NewReader(chunk), httpsendhead, cookie, contimeout, datatrantimeout, func(h http.Header) {
+		h.Set("Content-Type", "application/offset+octet-stream")
+		h.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	})
+	if response == nil {
+		return offset, code
+	}
+	defer response.Body.Close()
+	if code != http.StatusNoContent && code != http.StatusOK {
+		return offset, code
+	}
+	newoffset, err := strconv.ParseInt(response.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return offset, 6
+	}
+	return newoffset, code
+}
+
+//TusTerminate deletes an upload, httpretcode is 204 on success.
+func (c *Client) TusTerminate(uploadurl string, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (httpretcode int) {
+	response, code := c.tusRequest("DELETE", uploadurl, nil, httpsendhead, cookie, contimeout, datatrantimeout, nil)
+	if response != nil {
+		response.Body.Close()
+	}
+	return code
+}
+
+//TusUploadFile uploads a file in chunks of chunksize bytes (0 means 4MB). An empty uploadurl creates
+//a new upload and oncreate, if not nil, gets its url to persist; passing a persisted uploadurl resumes
+//from the offset the server reports. The upload is complete when offset equals the file size.
+//httpretcode 4 means the file cannot be read.
+func (c *Client) TusUploadFile(endpoint, uploadurl, filepath string, chunksize int64, metadata map[string]string, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration, oncreate func(uploadurl string)) (retuploadurl string, offset int64, httpretcode int) {
+	if chunksize <= 0 {
+		chunksize = 4 << 20
+	}
+	f, err := os.Open(filepath)
+	if err != nil {
+		return uploadurl, 0, 4
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return uploadurl, 0, 4
+	}
+	size := fi.Size()
+
+	var code int
+	if uploadurl == "" {
+		uploadurl, code = c.TusCreate(endpoint, size, metadata, httpsendhead, cookie, contimeout, datatrantimeout)
+		if code != http.StatusCreated {
+			return "", 0, code
+		}
+		if oncreate != nil {
+			oncreate(uploadurl)
+		}
+	} else {
+		var serversize int64
+		offset, serversize, code = c.TusOffset(uploadurl, httpsendhead, cookie, contimeout, datatrantimeout)
+		if code != http.StatusOK && code != http.StatusNoContent {
+			return uploadurl, 0, code
+		}
+		if serversize >= 0 && serversize != size {
+			return uploadurl, offset, 3
+		}
+	}
+
+	chunk := make([]byte, chunksize)
+	for offset < size {
+		n, err := f.ReadAt(chunk, offset)
+		if err != nil && err != io.EOF {
+			return uploadurl, offset, 4
+		}
+		var newoffset int64
+		newoffset, code = c.TusPatch(uploadurl, offset, chunk[:n], httpsendhead, cookie, contimeout, datatrantimeout)
+		if code != http.StatusNoContent && code != http.StatusOK {
+			return uploadurl, offset, code
+		}
+		if newoffset <= offset {
+			//the server accepted nothing, stop instead of looping
+			return uploadurl, offset, 6
+		}
+		offset = newoffset
+	}
+	return uploadurl, offset, code
+}
+
+func TusCreate(endpoint string, size int64, metadata map[string]string, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (uploadurl string, httpretcode int) {
+	return DefaultClient.TusCreate(endpoint, size, metadata, httpsendhead, cookie, contimeout, datatrantimeout)
+}
+
+func TusOffset(uploadurl string, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (offset, size int64, httpretcode int) {
+	return DefaultClient.TusOffset(uploadurl, httpsendhead, cookie, contimeout, datatrantimeout)
+}
+
+func TusPatch(uploadurl string, offset int64, chunk []byte, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (newoffset int64, httpretcode int) {
+	return DefaultClient.TusPatch(uploadurl, offset, chunk, httpsendhead, cookie, contimeout, datatrantimeout)
+}
+
+func TusTerminate(uploadurl string, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration) (httpretcode int) {
+	return DefaultClient.TusTerminate(uploadurl, httpsendhead, cookie, contimeout, datatrantimeout)
+}
+
+func TusUploadFile(endpoint, uploadurl, filepath string, chunksize int64, metadata map[string]string, httpsendhead []string, cookie []*http.Cookie, contimeout, datatrantimeout time.Duration, oncreate func(uploadurl string)) (retuploadurl string, offset int64, httpretcode int) {
+	return DefaultClient.TusUploadFile(endpoint, uploadurl, filepath, chunksize, metadata, httpsendhead, cookie, contimeout, datatrantimeout, oncreate)
+}
@@ -0,0 +1,184 @@
+// netutil project tus_test.go
+package netutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+//tusTestServer serves a tusServer at /files, failpatch makes the PATCH requests from that
+//number on fail with 503 before the tusServer sees them, 0 never.
+type tusTestServer struct {
+	*tusServer
+	mu        sync.Mutex
+	patches   int
+	patched   int64
+	failpatch int
+}
+
+func (s *tusTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PATCH" {
+		s.mu.Lock()
+		s.patches++
+		fail := s.failpatch > 0 && s.patches >= s.failpatch
+		s.patched += r.ContentLength
+		s.mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	s.tusServer.ServeHTTP(w, r)
+}
+
+func newTusTestServer(t *testing.T, maxchunk int64) (*tusTestServer, string) {
+	s := &tusTestServer{tusServer: newTusServer("/files")}
+	s.MaxChunk = maxchunk
+	return s, newTestServer(t, s.ServeHTTP).URL + "/files"
+}
+
+func uploadPath(t *testing.T, uploadurl string) string {
+	u, err := url.Parse(uploadurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Path
+}
+
+func randomFile(t *testing.T, size int) (string, []byte) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return writeTestFile(t, filepath.Join(t.TempDir(), "upload.bin"), string(data)), data
+}
+
+func TestTusCreateOffsetPatchTerminate(t *testing.T) {
+	s, endpoint := newTusTestServer(t, 0)
+	uploadurl, code := TusCreate(endpoint, 10, map[string]string{"filename": "a.txt", "type": "text/plain"}, nil, nil, time.Second, 5*time.Second)
+	if code != 201 || uploadurl == "" {
+		t.Fatalf("TusCreate %q %d", uploadurl, code)
+	}
+	path := uploadPath(t, uploadurl)
+	_, metadata, ok := s.upload(path)
+	want := "filename " + base64.StdEncoding.EncodeToString([]byte("a.txt")) + ",type " + base64.StdEncoding.EncodeToString([]byte("text/plain"))
+	if !ok || metadata != want {
+		t.Errorf("Upload-Metadata %q, want %q", metadata, want)
+	}
+
+	if offset, size, code := TusOffset(uploadurl, nil, nil, time.Second, 5*time.Second); code != 200 || offset != 0 || size != 10 {
+		t.Fatalf("TusOffset %d %d %d", offset, size, code)
+	}
+	if offset, code := TusPatch(uploadurl, 0, []byte("hello"), nil, nil, time.Second, 5*time.Second); code != 204 || offset != 5 {
+		t.Fatalf("TusPatch %d %d", offset, code)
+	}
+	//a wrong offset is refused and keeps the offset of the caller
+	if offset, code := TusPatch(uploadurl, 3, []byte("xx"), nil, nil, time.Second, 5*time.Second); code != 409 || offset != 3 {
+		t.Errorf("TusPatch at a wrong offset %d %d, want 3 409", offset, code)
+	}
+	if offset, code := TusPatch(uploadurl, 5, []byte("world"), nil, nil, time.Second, 5*time.Second); code != 204 || offset != 10 {
+		t.Fatalf("TusPatch %d %d", offset, code)
+	}
+	if offset, size, code := TusOffset(uploadurl, nil, nil, time.Second, 5*time.Second); code != 200 || offset != 10 || size != 10 {
+		t.Fatalf("TusOffset %d %d %d", offset, size, code)
+	}
+	if data, _, _ := s.upload(path); string(data) != "helloworld" {
+		t.Errorf("server got %q", data)
+	}
+
+	if code := TusTerminate(uploadurl, nil, nil, time.Second, 5*time.Second); code != 204 {
+		t.Fatalf("TusTerminate %d", code)
+	}
+	if _, _, ok := s.upload(path); ok {
+		t.Error("upload still exists after TusTerminate")
+	}
+	if _, _, code := TusOffset(uploadurl, nil, nil, time.Second, 5*time.Second); code != 404 {
+		t.Errorf("TusOffset of a terminated upload %d, want 404", code)
+	}
+	if _, code := TusCreate(endpoint, -1, nil, nil, nil, time.Second, time.Second); code != 3 {
+		t.Errorf("TusCreate of a negative size %d, want 3", code)
+	}
+}
+
+func TestTusUploadFileMaxChunk(t *testing.T) {
+	s, endpoint := newTusTestServer(t, 1000)
+	file, data := randomFile(t, 10000)
+	var created string
+	uploadurl, offset, code := TusUploadFile(endpoint, "", file, 4096, nil, nil, nil, time.Second, 5*time.Second, func(u string) { created = u })
+	if code != 204 || offset != 10000 || uploadurl == "" || created != uploadurl {
+		t.Fatalf("TusUploadFile %q %d %d, oncreate got %q", uploadurl, offset, code, created)
+	}
+	got, _, _ := s.upload(uploadPath(t, uploadurl))
+	if !bytes.Equal(got, data) {
+		t.Fatal("uploaded data differs")
+	}
+	//every PATCH was cut to MaxChunk, the client went on from the offset the server took
+	if s.patches != 10 {
+		t.Errorf("%d PATCH requests, want 10", s.patches)
+	}
+}
+
+func TestTusUploadFileResume(t *testing.T) {
+	s, endpoint := newTusTestServer(t, 0)
+	s.failpatch = 3
+	file, data := randomFile(t, 10000)
+	var persisted string
+	uploadurl, offset, code := TusUploadFile(endpoint, "", file, 3000, nil, nil, nil, time.Second, 5*time.Second, func(u string) { persisted = u })
+	if code != 503 || offset != 6000 || uploadurl != persisted {
+		t.Fatalf("interrupted TusUploadFile %q %d %d", uploadurl, offset, code)
+	}
+
+	s.mu.Lock()
+	s.failpatch = 0
+	s.patched = 0
+	s.mu.Unlock()
+	uploadurl, offset, code = TusUploadFile(endpoint, persisted, file, 3000, nil, nil, nil, time.Second, 5*time.Second, func(string) {
+		t.Error("resuming created a new upload")
+	})
+	if code != 204 || offset != 10000 || uploadurl != persisted {
+		t.Fatalf("resumed TusUploadFile %q %d %d", uploadurl, offset, code)
+	}
+	if s.patched != 4000 {
+		t.Errorf("resuming sent %d bytes, want the 4000 missing", s.patched)
+	}
+	got, _, _ := s.upload(uploadPath(t, uploadurl))
+	if !bytes.Equal(got, data) {
+		t.Fatal("uploaded data differs")
+	}
+}
+
+func TestTusUploadFileErrors(t *testing.T) {
+	_, endpoint := newTusTestServer(t, 0)
+	if _, _, code := TusUploadFile(endpoint, "", filepath.Join(t.TempDir(), "missing"), 0, nil, nil, nil, time.Second, time.Second, nil); code != 4 {
+		t.Errorf("missing file httpretcode %d, want 4", code)
+	}
+	file, _ := randomFile(t, 100)
+	uploadurl, code := TusCreate(endpoint, 50, nil, nil, nil, time.Second, 5*time.Second)
+	if code != 201 {
+		t.Fatalf("TusCreate %d", code)
+	}
+	//resuming an upload of another size
+	if _, _, code := TusUploadFile(endpoint, uploadurl, file, 0, nil, nil, nil, time.Second, 5*time.Second, nil); code != 3 {
+		t.Errorf("size mismatch httpretcode %d, want 3", code)
+	}
+	if _, _, code := TusUploadFile(endpoint, endpoint+"/404", file, 0, nil, nil, nil, time.Second, 5*time.Second, nil); code != 404 {
+		t.Errorf("unknown upload httpretcode %d, want 404", code)
+	}
+}
+
+func TestTusServerRequiresVersion(t *testing.T) {
+	srv := newTestServer(t, newTusServer("/files").ServeHTTP)
+	resp, err := http.Post(srv.URL+"/files", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed || resp.Header.Get("Tus-Version") != TusVersion {
+		t.Errorf("request without Tus-Resumable: %d %q", resp.StatusCode, resp.Header.Get("Tus-Version"))
+	}
+}
@@ -0,0 +1,119 @@
+// netutil project tusserver_test.go
+package netutil
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//tusServer is an in-memory tus 1.0.0 server with the creation and termination extensions,
+//serve it with net/http/httptest and point the Tus* functions at BasePath.
+type tusServer struct {
+	BasePath string
+	//MaxChunk, when > 0, makes PATCH accept only the first MaxChunk bytes of a request, like an interrupted transfer.
+	MaxChunk int64
+
+	mu      sync.Mutex
+	nextid  int
+	uploads map[string]*tusServerUpload
+}
+
+type tusServerUpload struct {
+	length   int64
+	metadata string
+	data     []byte
+}
+
+func newTusServer(basepath string) *tusServer {
+	return &tusServer{BasePath: strings.TrimRight(basepath, "/"), uploads: map[string]*tusServerUpload{}}
+}
+
+//upload returns the received data and Upload-Metadata of the upload at path uploadpath.
+func (s *tusServer) upload(uploadpath string) (data []byte, metadata string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[uploadpath]
+	if !ok {
+		return nil, "", false
+	}
+	return append([]byte(nil), u.data...), u.metadata, true
+}
+
+func (s *tusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", TusVersion)
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Tus-Version", TusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path == s.BasePath || r.URL.Path == s.BasePath+"/" {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.nextid++
+		uploadpath := s.BasePath + "/" + strconv.Itoa(s.nextid)
+		s.uploads[uploadpath] = &tusServerUpload{length: length, metadata: r.Header.Get("Upload-Metadata")}
+		w.Header().Set("Location", uploadpath)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	u, ok := s.uploads[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "HEAD":
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(u.data)))
+		w.Header().Set("Upload-Length", strconv.FormatInt(u.length, 10))
+		w.WriteHeader(http.StatusOK)
+	case "PATCH":
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset != int64(len(u.data)) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		limit := u.length - offset
+		if s.MaxChunk > 0 && s.MaxChunk < limit {
+			limit = s.MaxChunk
+		}
+		chunk, err := ioutil.ReadAll(io.LimitReader(r.Body, limit))
+		u.data = append(u.data, chunk...)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(u.data)))
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.uploads, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,137 @@
+// netutil project writer.go
+package netutil
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//UrlGetToWriter streams the decoded body of httpurl into w, e.g. a hash, a tar writer or an upload.
+//Nothing is written for a status other than 2xx. written is the number of bytes written, httpretcode
+//is the status code, 3 for bad httpgetdata, 5 uncompress error, 6 when w failed.
+func (c *Client) UrlGetToWriter(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, w io.Writer, contimeout, datatrantimeout time.Duration) (written int64, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	httpurl, ok := appendQuery(httpurl, httpgetdata)
+	if !ok {
+		return 0, http.Header{}, nil, 3, redilocation, nil
+	}
+	cw := &countWriter{}
+	response, code, redilocation, err := c.fetch(httpurl, httpsendhead, cookie, nil, false, contimeout, datatrantimeout, func(*http.Response) (io.Writer, error) {
+		return io.MultiWriter(w, cw), nil
+	})
+	if response == nil {
+		return 0, http.Header{}, nil, code, redilocation, err
+	}
+	return cw.n, response.Header, response.Cookies(), code, redilocation, err
+}
+
+//contentRangeSize returns the complete length of a "bytes 0-0/1234" Content-Range, -1 if unknown.
+func contentRangeSize(contentrange string) int64 {
+	i := strings.LastIndex(contentrange, "/")
+	if !strings.HasPrefix(contentrange, "bytes ") || i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(contentrange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+//UrlGetToWriterAt downloads httpurl into w, e.g. a preallocated file or buffer, in segments (0 means 4)
+//fetched in parallel with range requests. A first request for byte 0 finds the size; when the server
+//does not support ranges its full answer is written from offset 0 instead. The segments carry the
+//ETag or Last-Modified as If-Range, a resource changing meanwhile fails the download. size is the
+//length written, httpretcode 200 on success, 3 for bad httpgetdata, 6 when w failed or a segment was
+//not answered with its range, else the code of the failed request.
+func (c *Client) UrlGetToWriterAt(httpurl string, httpgetdata []string, httpsendhead []string, cookie []*http.Cookie, w io.WriterAt, segments int, contimeout, datatrantimeout time.Duration) (size int64, head http.Header, retcookie []*http.Cookie, httpretcode int, redilocation string, err error) {
+	httpurl, ok := appendQuery(httpurl, httpgetdata)
+	if !ok {
+		return 0, http.Header{}, nil, 3, redilocation, nil
+	}
+	if segments <= 0 {
+		segments = 4
+	}
+	//the size request and the segments share the connections
+	client := c.newHttpClient(contimeout, datatrantimeout, &redilocation)
+	defer client.CloseIdleConnections()
+	request, err := http.NewRequest("GET", httpurl, nil)
+	if err != nil {
+		return 0, http.Header{}, nil, 1, redilocation, err
+	}
+	setRequestHead(request, "", httpsendhead, cookie)
+	request.Header.Set("Range", "bytes=0-0")
+	request.Header.Set("Accept-Encoding", "identity")
+	response, err := c.do(client, request)
+	if err != nil {
+		return 0, http.Header{}, nil, doErrCode(err), redilocation, err
+	}
+	head, retcookie = response.Header, response.Cookies()
+	size = -1
+	if response.StatusCode == http.StatusPartialContent {
+		size = contentRangeSize(response.Header.Get("Content-Range"))
+	}
+	if size < 0 {
+		//no range support, or an empty resource answered with 416
+		defer response.Body.Close()
+		if response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			size, _, _, httpretcode, _, err = c.UrlGetToWriter(httpurl, nil, httpsendhead, cookie, &offsetWriter{w, 0}, contimeout, datatrantimeout)
+			return size, head, retcookie, httpretcode, redilocation, err
+		}
+		if response.StatusCode < 200 || response.StatusCode >= 300 {
+			return 0, head, retcookie, response.StatusCode, redilocation, nil
+		}
+		body, err := uncompressReader(response.Body, response.Header.Get("Content-Encoding"))
+		if err != nil {
+			return 0, head, retcookie, 5, redilocation, err
+		}
+		defer body.Close()
+		dest := &errWriter{w: &offsetWriter{w, 0}}
+		if size, err = io.Copy(dest, body); dest.err != nil {
+			return size, head, retcookie, 6, redilocation, dest.err
+		} else if err != nil {
+			return size, head, retcookie, doErrCode(err), redilocation, err
+		}
+		return size, head, retcookie, response.StatusCode, redilocation, nil
+	}
+	response.Body.Close()
+
+	//segment against the final url, not the redirects before it
+	httpurl = response.Request.URL.String()
+	ifrange := response.Header.Get("ETag")
+	if ifrange == "" || strings.HasPrefix(ifrange, "W/") {
+		ifrange = response.Header.Get("Last-Modified")
+	}
+	length := (size + int64(segments) - 1) / int64(segments)
+	if length < 1<<20 {
+		length = 1 << 20
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for start := int64(0); start < size; start += length {
+		p := filePiece{start: start, end: start + length - 1}
+		if p.end >= size {
+			p.end = size - 1
+		}
+		wg.Add(1)
+		go func(p filePiece) {
+			defer wg.Done()
+			code, perr := c.fetchPiece(client, httpurl, w, p, ifrange, httpsendhead, cookie)
+			if perr != nil {
+				mu.Lock()
+				if err == nil {
+					httpretcode, err = code, perr
+				}
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+	if err != nil {
+		return 0, head, retcookie, httpretcode, redilocation, err
+	}
+	return size, head, retcookie, http.StatusOK, redilocation, nil
+}
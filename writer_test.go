@@ -0,0 +1,174 @@
+// netutil project writer_test.go
+package netutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+//memWriterAt is a growing in-memory io.WriterAt.
+type memWriterAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (m *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if end := int(off) + len(p); end > len(m.buf) {
+		m.buf = append(m.buf, make([]byte, end-len(m.buf))...)
+	}
+	return copy(m.buf[off:], p), nil
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+//segmentServer serves data with ranges under an ETag, and under a weak ETag and a Last-Modified at
+//weak, without range support at norange, with an ETag changing every request at changing and
+//nothing at empty.
+//It records the If-Range of the range requests.
+type segmentServer struct {
+	*testServer
+	mu      sync.Mutex
+	hits    int
+	ifrange []string
+}
+
+func newSegmentServer(t *testing.T, data []byte) *segmentServer {
+	s := &segmentServer{}
+	modtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.testServer = newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits++
+		hits := s.hits
+		if r.Header.Get("If-Range") != "" {
+			s.ifrange = append(s.ifrange, r.Header.Get("If-Range"))
+		}
+		s.mu.Unlock()
+		switch r.URL.Path {
+		case "/norange":
+			w.Write(data)
+			return
+		case "/changing":
+			w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, hits))
+		case "/weak":
+			w.Header().Set("ETag", `W/"v1"`)
+			http.ServeContent(w, r, "", modtime, bytes.NewReader(data))
+			return
+		case "/empty":
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(nil))
+			return
+		default:
+			w.Header().Set("ETag", `"v1"`)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	return s
+}
+
+func (s *segmentServer) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits = 0
+	s.ifrange = nil
+}
+
+func TestUrlGetToWriter(t *testing.T) {
+	_, data := randomFile(t, 100000)
+	srv := newSegmentServer(t, data)
+	h := sha256.New()
+	n, _, _, code, _, err := UrlGetToWriter(srv.URL, nil, nil, nil, h, time.Second, 5*time.Second)
+	if n != int64(len(data)) || code != 200 || err != nil {
+		t.Fatalf("written %d httpretcode %d %v", n, code, err)
+	}
+	if sum := sha256.Sum256(data); !bytes.Equal(h.Sum(nil), sum[:]) {
+		t.Error("written content differs")
+	}
+	if _, _, _, code, _, err := UrlGetToWriter(srv.URL, nil, nil, nil, failingWriter{}, time.Second, 5*time.Second); code != 6 || err == nil || err.Error() != "disk full" {
+		t.Errorf("failing writer: httpretcode %d %v", code, err)
+	}
+}
+
+func TestUrlGetToWriterAt(t *testing.T) {
+	_, data := randomFile(t, 3<<20+5)
+	srv := newSegmentServer(t, data)
+
+	w := &memWriterAt{}
+	size, _, _, code, _, err := UrlGetToWriterAt(srv.URL, nil, nil, nil, w, 4, time.Second, 5*time.Second)
+	if size != int64(len(data)) || code != 200 || err != nil || !bytes.Equal(w.buf, data) {
+		t.Fatalf("size %d httpretcode %d %v, content equal %v", size, code, err, bytes.Equal(w.buf, data))
+	}
+	//the size request then 4 segments of at least 1MB, each sending the ETag as If-Range
+	if srv.hits != 5 || len(srv.ifrange) != 4 || srv.ifrange[0] != `"v1"` {
+		t.Errorf("%d requests, If-Range %v", srv.hits, srv.ifrange)
+	}
+
+	//a weak ETag cannot be used for If-Range, the Last-Modified is
+	srv.reset()
+	w = &memWriterAt{}
+	if _, _, _, code, _, err := UrlGetToWriterAt(srv.URL+"/weak", nil, nil, nil, w, 2, time.Second, 5*time.Second); code != 200 || err != nil || !bytes.Equal(w.buf, data) {
+		t.Errorf("weak ETag: httpretcode %d %v", code, err)
+	}
+	if len(srv.ifrange) == 0 || srv.ifrange[0] != "Wed, 01 May 2024 12:00:00 GMT" {
+		t.Errorf("weak ETag: If-Range %v", srv.ifrange)
+	}
+}
+
+func TestUrlGetToWriterAtFallbacks(t *testing.T) {
+	_, data := randomFile(t, 3<<20+5)
+	srv := newSegmentServer(t, data)
+
+	//without range support the whole answer to the first request is written
+	w := &memWriterAt{}
+	size, _, _, code, _, err := UrlGetToWriterAt(srv.URL+"/norange", nil, nil, nil, w, 4, time.Second, 5*time.Second)
+	if size != int64(len(data)) || code != 200 || err != nil || !bytes.Equal(w.buf, data) || srv.hits != 1 {
+		t.Errorf("no range support: size %d httpretcode %d %v, %d requests", size, code, err, srv.hits)
+	}
+
+	srv.reset()
+	w = &memWriterAt{}
+	if size, _, _, code, _, err := UrlGetToWriterAt(srv.URL+"/empty", nil, nil, nil, w, 4, time.Second, 5*time.Second); size != 0 || code != 200 || err != nil {
+		t.Errorf("empty: size %d httpretcode %d %v", size, code, err)
+	}
+
+	//a resource changing during the download fails instead of mixing versions
+	srv.reset()
+	if _, _, _, code, _, err := UrlGetToWriterAt(srv.URL+"/changing", nil, nil, nil, &memWriterAt{}, 4, time.Second, 5*time.Second); code != 6 || err == nil {
+		t.Errorf("changing: httpretcode %d %v", code, err)
+	}
+}
+
+//TestUrlGetToWriterAtConnections checks the segments share connections which are closed at the end.
+func TestUrlGetToWriterAtConnections(t *testing.T) {
+	_, data := randomFile(t, 8<<20)
+	srv := newSegmentServer(t, data)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		if _, _, _, code, _, err := UrlGetToWriterAt(srv.URL, nil, nil, nil, &memWriterAt{}, 8, time.Second, 5*time.Second); code != 200 || err != nil {
+			t.Fatalf("httpretcode %d %v", code, err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before+2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before+2 {
+		t.Errorf("%d goroutines left after the downloads, %d before", n, before)
+	}
+	//at most one connection per segment and one for the size request
+	if srv.connections() > 5*9 {
+		t.Errorf("%d connections for 5 downloads of 8 segments", srv.connections())
+	}
+	if !srv.closed() {
+		t.Error("connections left open after the downloads")
+	}
+}